@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runDoctor checks everything the prompt segment depends on and returns the
+// process exit code: 0 when all checks pass, 1 otherwise.
+func runDoctor(ctx context.Context, w io.Writer) int {
+	failed := false
+	check := func(level, name, detail string) {
+		if level == "fail" {
+			failed = true
+		}
+		fmt.Fprintf(w, "[%s] %-10s %s\n", level, name, detail)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		check("fail", "config", fmt.Sprintf("%s: %v", getConfigFilePath(), err))
+		return 1
+	}
+	check("ok", "config", getConfigFilePath())
+
+	protoPath, err := exec.LookPath(resolveProtoPath(config))
+	if err != nil {
+		check("fail", "proto", fmt.Sprintf("%s: %v", resolveProtoPath(config), err))
+		return 1
+	}
+	check("ok", "proto", protoPath)
+
+	if err := validateTemplate(config, toolTemplate(config)); err != nil {
+		check("fail", "template", err.Error())
+	} else {
+		check("ok", "template", "parses")
+	}
+	if partials := partialNames(config); len(partials) > 0 {
+		check("ok", "templates", strings.Join(partials, ", "))
+	}
+
+	for state := range config.States {
+		if !containsString(toolStates, state) {
+			check("warn", "states", fmt.Sprintf("unknown state %q, expected one of %s", state, strings.Join(toolStates, ", ")))
+		}
+	}
+	for _, state := range config.Display.HideWhen {
+		if !containsString(toolStates, state) {
+			check("warn", "display", fmt.Sprintf("unknown state %q in hide_when", state))
+		}
+	}
+	for _, source := range config.Display.ShowOnlySources {
+		if !containsString([]string{SourceLocal, SourceInherited, SourceGlobal}, source) {
+			check("warn", "display", fmt.Sprintf("unknown source %q in show_only_sources", source))
+		}
+	}
+	if ratio := config.Display.MaxWidthRatio; ratio < 0 || ratio > 1 {
+		check("warn", "display", fmt.Sprintf("max_width_ratio %g is not between 0 and 1", ratio))
+	} else if ratio > 0 && os.Getenv("COLUMNS") == "" {
+		check("warn", "display", "max_width_ratio has no effect, $COLUMNS is not set")
+	}
+	if !containsString(sortModes, getSortMode(config)) {
+		check("warn", "sort", fmt.Sprintf("unknown sort %q, expected one of %s", config.Sort, strings.Join(sortModes, ", ")))
+	}
+	if len(config.States) > 0 && toolTemplate(config) == config.Template {
+		check("warn", "states", "ignored because template is set")
+	}
+
+	optionalTemplates := []struct{ name, tmpl string }{
+		{"layout_template", config.LayoutTemplate},
+		{"error_template", config.ErrorTemplate},
+	}
+	toolNames := make([]string, 0, len(config.Tools))
+	for tool := range config.Tools {
+		toolNames = append(toolNames, tool)
+	}
+	sort.Strings(toolNames)
+	for _, tool := range toolNames {
+		optionalTemplates = append(optionalTemplates, struct{ name, tmpl string }{"tools." + tool + ".template", config.Tools[tool].Template})
+	}
+	for _, optional := range optionalTemplates {
+		if optional.tmpl == "" {
+			continue
+		}
+		var provided []string
+		if optional.name == "layout_template" {
+			provided = []string{"tool"}
+		}
+		if err := validateTemplate(config, optional.tmpl, provided...); err != nil {
+			check("fail", optional.name, err.Error())
+		} else {
+			check("ok", optional.name, "parses")
+		}
+	}
+
+	if _, err := readCache(); err != nil && !os.IsNotExist(err) {
+		check("warn", "cache", fmt.Sprintf("%s: %v", getCacheFile(), err))
+	} else {
+		check("ok", "cache", getCacheFile())
+	}
+
+	version, err := detectProtoVersion(ctx, config)
+	if err != nil {
+		check("fail", "version", err.Error())
+	} else {
+		check("ok", "version", "proto "+version.String())
+	}
+
+	if output, err := runProto(ctx, config, protoJSONArgs("status", config)); err != nil {
+		check("fail", "status", describeError(err))
+	} else if tools, err := protoSchema.DecodeStatus(output); err != nil {
+		check("fail", "status", err.Error())
+	} else {
+		check("ok", "status", fmt.Sprintf("%d tools", len(tools)))
+	}
+
+	if isOffline(config) {
+		check("ok", "outdated", "skipped, offline")
+	} else if output, err := runProto(ctx, config, protoJSONArgs("outdated", config)); err != nil {
+		check("warn", "outdated", describeError(err))
+	} else if tools, err := protoSchema.DecodeOutdated(output); err != nil {
+		check("fail", "outdated", err.Error())
+	} else {
+		check("ok", "outdated", fmt.Sprintf("%d tools", len(tools)))
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// runExplain describes how the prompt for the current directory is produced:
+// which files feed the cache key, whether the cache answers, and what proto
+// reported for each tool. It returns the process exit code.
+func runExplain(ctx context.Context, w io.Writer) int {
+	line := func(label, value string) {
+		fmt.Fprintf(w, "%-18s %s\n", label+":", value)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		line("Config", fmt.Sprintf("%s: %v", getConfigFilePath(), err))
+		return 1
+	}
+
+	wd, _ := os.Getwd()
+	homeDir, _ := os.UserHomeDir()
+	line("Working directory", wd)
+	line("Config file", getConfigFilePath())
+	line("Cache file", getCacheFile())
+	line("Config mode", getConfigMode(config.ConfigMode))
+	line("Proto command", strings.Join(append([]string{resolveProtoPath(config)}, config.Proto.Args...), " "))
+	if config.TemplateFile != "" {
+		line("Template file", resolveTemplateFile(config.TemplateFile, getConfigFilePath()))
+	}
+	if partials := partialNames(config); len(partials) > 0 {
+		line("Partials", strings.Join(partials, ", "))
+	}
+	line("Offline", fmt.Sprintf("%t", isOffline(config)))
+	line("Sort", getSortMode(config))
+
+	if dirHash, err := getDirectoryContext(config); err != nil {
+		line("Cache key", err.Error())
+	} else {
+		line("Cache key", dirHash)
+	}
+
+	_, stop := walkDirs(wd, homeDir, config.Walk)
+	line("Walk stopped at", stop)
+	if protoEnv := getProtoEnv(config); protoEnv != "" {
+		line("Proto env", protoEnv)
+	}
+	fmt.Fprintln(w, ".prototools files:")
+	for _, file := range prototoolsFilesForMode(wd, homeDir, config) {
+		fmt.Fprintf(w, "  %s\n", file)
+	}
+
+	if pins, _, err := loadConfiguredVersions(wd, homeDir, config); err != nil {
+		line("Pins", err.Error())
+	} else {
+		fmt.Fprintln(w, "Pins:")
+		pinned := make([]string, 0, len(pins))
+		for tool := range pins {
+			pinned = append(pinned, tool)
+		}
+		sort.Strings(pinned)
+		for _, tool := range pinned {
+			fmt.Fprintf(w, "  %s = %q (%s)\n", tool, pins[tool].ConfigVersion, pins[tool].ConfigSource)
+		}
+	}
+
+	if _, ok := readInventoryStatus(config); ok {
+		line("Inventory", "resolved locally from "+filepath.Join(getProtoHome(config, homeDir), "tools"))
+	} else {
+		line("Inventory", "ambiguous, proto status is used")
+	}
+
+	ttl := getCacheTTL(config)
+	if entry, ok := lookupCacheEntry(config); !ok {
+		line("Cache entry", "missing")
+	} else {
+		age := time.Since(time.Unix(entry.Timestamp, 0)).Truncate(time.Second)
+		state := "fresh"
+		if !isCacheEntryValid(entry, ttl) {
+			state = "expired"
+		}
+		if entry.StatusOnly {
+			state += ", status only"
+		}
+		line("Cache entry", fmt.Sprintf("%s (age %s, ttl %ds)", state, age, ttl))
+		for _, protoErr := range entry.Errors {
+			line("Last error", describeError(&protoErr))
+		}
+		for _, renderErr := range entry.RenderErrors {
+			line("Render error", renderErr.Error())
+		}
+	}
+
+	if _, ok := readRenderCache(); ok {
+		line("Fast path", "hit, "+getRenderFile())
+	} else {
+		line("Fast path", "miss")
+	}
+
+	version, err := detectProtoVersion(ctx, config)
+	if err != nil {
+		line("Proto", err.Error())
+		return 1
+	}
+	line("Proto", version.String())
+
+	exitCode := 0
+	tools, outdated := map[string]ToolStatus{}, map[string]OutdatedStatus{}
+	if cached, ok := getCachedData(config); ok {
+		line("Data source", "cache")
+		tools, outdated = cached.StatusData, cached.OutdatedData
+	} else {
+		line("Data source", "proto")
+		if output, err := runProto(ctx, config, protoJSONArgs("status", config)); err != nil {
+			line("Status", describeError(err))
+			exitCode = 1
+		} else if tools, err = protoSchema.DecodeStatus(output); err != nil {
+			line("Status", err.Error())
+			exitCode = 1
+		}
+		if isOffline(config) {
+			line("Outdated", "skipped, offline")
+		} else if output, err := runProto(ctx, config, protoJSONArgs("outdated", config)); err != nil {
+			line("Outdated", describeError(err))
+		} else if outdated, err = protoSchema.DecodeOutdated(output); err != nil {
+			line("Outdated", err.Error())
+			exitCode = 1
+		}
+	}
+
+	toolNames := make([]string, 0, len(tools))
+	for tool := range tools {
+		toolNames = append(toolNames, tool)
+	}
+	sort.Strings(toolNames)
+
+	fmt.Fprintln(w, "Tools:")
+	for _, tool := range toolNames {
+		status := tools[tool]
+		out := outdated[tool]
+		fmt.Fprintf(w, "  %s\n", tool)
+		fmt.Fprintf(w, "    installed: %t, resolved: %s, config: %s\n", status.IsInstalled, status.ResolvedVersion, status.ConfigVersion)
+		fmt.Fprintf(w, "    newest: %s, latest: %s\n", out.NewestVersion, out.LatestVersion)
+		if status.ConfigSource != "" {
+			fmt.Fprintf(w, "    source: %s (%s)\n", status.ConfigSource, configSourceKind(config, wd, homeDir, status.ConfigSource))
+		}
+	}
+
+	return exitCode
+}
+
+// describeError renders an error for doctor and explain, including the exit
+// code, duration and stderr of a failed proto invocation.
+func describeError(err error) string {
+	var protoErr *ProtoError
+	if !errors.As(err, &protoErr) {
+		return err.Error()
+	}
+
+	desc := fmt.Sprintf("%s: %s (exit %d, %dms)", protoErr.Command, protoErr.Message, protoErr.ExitCode, protoErr.DurationMs)
+	if protoErr.Stderr != "" {
+		desc += "\n" + indent(protoErr.Stderr, "    stderr: ")
+	}
+	return desc
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
@@ -0,0 +1,128 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// installFakeProto puts a proto script on PATH that answers with the
+// fixtures in fixtureDir.
+func installFakeProto(t *testing.T, fixtureDir string) {
+	t.Helper()
+
+	fixtures, err := filepath.Abs(fixtureDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binDir := t.TempDir()
+	script := `#!/bin/sh
+case "$1" in
+  --version) cat "` + fixtures + `/version.txt" ;;
+  status) cat "` + fixtures + `/status.json" ;;
+  outdated) cat "` + fixtures + `/outdated.json" ;;
+  *) exit 1 ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(binDir, "proto"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	skipInventory(t)
+}
+
+func useTempConfig(t *testing.T) {
+	t.Helper()
+
+	oldConfigPath := configPath
+	oldGetConfigFilePath := getConfigFilePath
+	oldLoadConfig := loadConfig
+	t.Cleanup(func() {
+		configPath = oldConfigPath
+		getConfigFilePath = oldGetConfigFilePath
+		loadConfig = oldLoadConfig
+	})
+
+	configFile := filepath.Join(t.TempDir(), "config.jsonc")
+	configPath = configFile
+	getConfigFilePath = func() string { return configFile }
+	loadConfig = func() (ProtoConfig, error) { return loadJSONConfig(configFile) }
+}
+
+func TestRunDoctor(t *testing.T) {
+	installFakeProto(t, filepath.Join("testdata", "proto", "0.45.2"))
+	useTempConfig(t)
+
+	var out bytes.Buffer
+	if code := runDoctor(context.Background(), &out); code != 0 {
+		t.Fatalf("runDoctor() = %d, want 0\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "proto 0.45.2") {
+		t.Errorf("runDoctor() should report the detected version\n%s", out.String())
+	}
+}
+
+func TestRunDoctorStatesWithDefaultTemplate(t *testing.T) {
+	installFakeProto(t, filepath.Join("testdata", "proto", "0.45.2"))
+	useTempConfig(t)
+
+	generated := strings.Replace(getDefaultConfigContent(), `"states": {},`, `"states": {"latest": {"color": "green"}},`, 1)
+	os.WriteFile(configPath, []byte(generated), 0644)
+
+	var out bytes.Buffer
+	runDoctor(context.Background(), &out)
+	if strings.Contains(out.String(), "ignored because template is set") {
+		t.Errorf("runDoctor() should use states with the generated template\n%s", out.String())
+	}
+
+	os.WriteFile(configPath, []byte(`{"template": "{{.Tool}}", "states": {"latest": {"color": "green"}}}`), 0644)
+	out.Reset()
+	runDoctor(context.Background(), &out)
+	if !strings.Contains(out.String(), "ignored because template is set") {
+		t.Errorf("runDoctor() should warn that a custom template hides states\n%s", out.String())
+	}
+}
+
+func TestRunDoctorUnknownShape(t *testing.T) {
+	fixtures := t.TempDir()
+	os.WriteFile(filepath.Join(fixtures, "version.txt"), []byte("proto 0.60.0\n"), 0644)
+	renamed, _ := os.ReadFile(filepath.Join("testdata", "schema", "renamed_status.json"))
+	os.WriteFile(filepath.Join(fixtures, "status.json"), renamed, 0644)
+	os.WriteFile(filepath.Join(fixtures, "outdated.json"), []byte("{}"), 0644)
+
+	installFakeProto(t, fixtures)
+	useTempConfig(t)
+
+	var out bytes.Buffer
+	if code := runDoctor(context.Background(), &out); code != 1 {
+		t.Fatalf("runDoctor() = %d, want 1\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "missing resolved_version") {
+		t.Errorf("runDoctor() should name the missing field\n%s", out.String())
+	}
+}
+
+func TestRunExplain(t *testing.T) {
+	installFakeProto(t, filepath.Join("testdata", "proto", "0.50.1"))
+	useTempConfig(t)
+
+	oldForceRefresh := forceRefresh
+	defer func() { forceRefresh = oldForceRefresh }()
+	forceRefresh = true
+
+	var out bytes.Buffer
+	if code := runExplain(context.Background(), &out); code != 0 {
+		t.Fatalf("runExplain() = %d, want 0\n%s", code, out.String())
+	}
+	for _, want := range []string{"Config mode:", "Cache key:", "Proto:             0.50.1", "bun", "resolved: 24.0.0"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("runExplain() output missing %q\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	stderrTailBytes = 2048
+	maxLogBytes     = 256 * 1024
+)
+
+// ProtoError describes a failed proto invocation. It is stored in the cache
+// next to the directory's entry and appended to the log file, so an empty
+// prompt can be traced back to the command that caused it.
+type ProtoError struct {
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exit_code"`           // -1 when proto did not exit on its own
+	Stderr     string `json:"stderr,omitempty"`    // Last bytes of stderr
+	DurationMs int64  `json:"duration_ms"`         // Time until the failure
+	Message    string `json:"message"`             // Underlying error
+	Timestamp  int64  `json:"timestamp,omitempty"` // When the failure happened
+	Err        error  `json:"-"`
+}
+
+func (e *ProtoError) Error() string {
+	msg := fmt.Sprintf("%s: %s after %dms", e.Command, e.Message, e.DurationMs)
+	if e.Stderr != "" {
+		msg += ": " + lastLine(e.Stderr)
+	}
+	return msg
+}
+
+func (e *ProtoError) Unwrap() error {
+	return e.Err
+}
+
+// newProtoError wraps err from running proto with args. Errors that already
+// are a ProtoError are returned unchanged.
+func newProtoError(args []string, err error, stderr string, duration time.Duration) *ProtoError {
+	var protoErr *ProtoError
+	if errors.As(err, &protoErr) {
+		return protoErr
+	}
+
+	exitCode := -1
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+
+	return &ProtoError{
+		Command:    strings.TrimSpace("proto " + strings.Join(args, " ")),
+		ExitCode:   exitCode,
+		Stderr:     tail(stderr, stderrTailBytes),
+		DurationMs: duration.Milliseconds(),
+		Message:    err.Error(),
+		Timestamp:  time.Now().Unix(),
+		Err:        err,
+	}
+}
+
+func tail(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	limit int
+	data  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = b.data[len(b.data)-b.limit:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	return string(b.data)
+}
+
+// getLogFile returns {config_name}.log in the directory of the cache file.
+var getLogFile = func() string {
+	cacheFile := getCacheFile()
+	configFile := getConfigFilePath()
+	if cacheFile == "" || configFile == "" {
+		return ""
+	}
+	configBase := filepath.Base(configFile)
+	configName := strings.TrimSuffix(configBase, filepath.Ext(configBase))
+	return filepath.Join(filepath.Dir(cacheFile), configName+".log")
+}
+
+// RenderError describes a tool segment that could not be rendered, e.g.
+// because a template function or color conversion panicked.
+type RenderError struct {
+	Tool      string `json:"tool,omitempty"` // Empty when the whole segment failed
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+func (e RenderError) Error() string {
+	if e.Tool == "" {
+		return "render: " + e.Message
+	}
+	return "render " + e.Tool + ": " + e.Message
+}
+
+// appendLog appends lines to the log file next to the cache. The log is
+// started over once it grows past maxLogBytes.
+func appendLog(lines []string) {
+	logFile := getLogFile()
+	if logFile == "" || len(lines) == 0 {
+		return
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if info, err := os.Stat(logFile); err == nil && info.Size() > maxLogBytes {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(logFile, flags, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	for _, line := range lines {
+		fmt.Fprintln(f, line)
+	}
+}
+
+func logProtoErrors(errs []*ProtoError) {
+	wd, _ := os.Getwd()
+	lines := make([]string, 0, len(errs))
+	for _, e := range errs {
+		lines = append(lines, fmt.Sprintf("%s %s exit=%d %s", time.Unix(e.Timestamp, 0).Format(time.RFC3339), wd, e.ExitCode, e.Error()))
+	}
+	appendLog(lines)
+}
+
+// recordProtoErrors stores errs in the cache entry for the current
+// directory without changing its data or age, and logs them.
+func recordProtoErrors(config ProtoConfig, errs []*ProtoError) {
+	if len(errs) == 0 {
+		return
+	}
+	logProtoErrors(errs)
+
+	cached, _ := readCache()
+	if cached.Entries == nil {
+		cached.Entries = make(map[string]DirectoryCacheData)
+	}
+
+	dirHash, err := getDirectoryContext(config)
+	if err != nil {
+		return
+	}
+
+	entry := cached.Entries[dirHash]
+	entry.Errors = make([]ProtoError, 0, len(errs))
+	for _, e := range errs {
+		entry.Errors = append(entry.Errors, *e)
+	}
+	cached.Entries[dirHash] = entry
+	writeCache(cached)
+}
+
+// recordRenderErrors stores errs in the cache entry for the current
+// directory without changing its data or age, and logs them.
+func recordRenderErrors(config ProtoConfig, errs []RenderError) {
+	if len(errs) == 0 {
+		return
+	}
+
+	wd, _ := os.Getwd()
+	lines := make([]string, 0, len(errs))
+	for _, e := range errs {
+		lines = append(lines, fmt.Sprintf("%s %s %s", time.Unix(e.Timestamp, 0).Format(time.RFC3339), wd, e.Error()))
+	}
+	appendLog(lines)
+
+	cached, _ := readCache()
+	if cached.Entries == nil {
+		cached.Entries = make(map[string]DirectoryCacheData)
+	}
+
+	dirHash, err := getDirectoryContext(config)
+	if err != nil {
+		return
+	}
+
+	entry := cached.Entries[dirHash]
+	entry.RenderErrors = errs
+	cached.Entries[dirHash] = entry
+	writeCache(cached)
+}
+
+// recoverRenderError converts a recovered panic value into a RenderError.
+func recoverRenderError(tool string, r any) RenderError {
+	return RenderError{Tool: tool, Message: fmt.Sprintf("panic: %v", r), Timestamp: time.Now().Unix()}
+}
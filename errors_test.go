@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type exitCodeError struct{ code int }
+
+func (e exitCodeError) Error() string { return fmt.Sprintf("exit status %d", e.code) }
+func (e exitCodeError) ExitCode() int { return e.code }
+
+func TestNewProtoError(t *testing.T) {
+	err := newProtoError([]string{"status", "--json"}, exitCodeError{2}, "warning\nerror: lock held\n", 150*time.Millisecond)
+
+	if err.Command != "proto status --json" || err.ExitCode != 2 || err.DurationMs != 150 {
+		t.Errorf("newProtoError() = %+v", err)
+	}
+	if !strings.HasSuffix(err.Error(), ": error: lock held") {
+		t.Errorf("Error() = %q, want last stderr line", err.Error())
+	}
+	if !errors.Is(err, err.Err) {
+		t.Error("ProtoError should unwrap to the underlying error")
+	}
+
+	if again := newProtoError(nil, err, "", 0); again != err {
+		t.Error("newProtoError() should not wrap a ProtoError twice")
+	}
+
+	if plain := newProtoError(nil, fmt.Errorf("not found"), "", 0); plain.ExitCode != -1 {
+		t.Errorf("ExitCode = %d, want -1 without an exit status", plain.ExitCode)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{limit: 5}
+	b.Write([]byte("abc"))
+	b.Write([]byte("defgh"))
+	if b.String() != "defgh" {
+		t.Errorf("tailBuffer = %q, want last 5 bytes", b.String())
+	}
+}
+
+func TestRecordProtoErrors(t *testing.T) {
+	oldGetCacheFile := getCacheFile
+	oldGetDirectoryContext := getDirectoryContext
+	defer func() {
+		getCacheFile = oldGetCacheFile
+		getDirectoryContext = oldGetDirectoryContext
+	}()
+
+	tempDir := t.TempDir()
+	getCacheFile = func() string { return filepath.Join(tempDir, "config.cache.json") }
+	getDirectoryContext = func(config ProtoConfig) (string, error) { return "test-hash", nil }
+
+	updateCache(map[string]ToolStatus{"node": {IsInstalled: true}}, nil, ProtoConfig{})
+	before, _ := lookupCacheEntry(ProtoConfig{})
+
+	recordProtoErrors(ProtoConfig{}, []*ProtoError{newProtoError([]string{"outdated", "--json"}, exitCodeError{1}, "registry offline", time.Second)})
+
+	entry, _ := lookupCacheEntry(ProtoConfig{})
+	if len(entry.Errors) != 1 || entry.Errors[0].Stderr != "registry offline" || entry.Errors[0].ExitCode != 1 {
+		t.Errorf("Errors = %+v", entry.Errors)
+	}
+	if entry.Timestamp != before.Timestamp || !entry.StatusData["node"].IsInstalled {
+		t.Error("recordProtoErrors() should keep the entry's data and age")
+	}
+
+	log, err := os.ReadFile(getLogFile())
+	if err != nil || !strings.Contains(string(log), "proto outdated --json") {
+		t.Errorf("log = %q, %v", log, err)
+	}
+}
+
+func TestGetOutdatedStatusReturnsError(t *testing.T) {
+	oldRunProtoCommand := runProtoCommand
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		runProtoCommand = oldRunProtoCommand
+		getCacheFile = oldGetCacheFile
+	}()
+
+	getCacheFile = func() string { return filepath.Join(t.TempDir(), "cache.json") }
+	runProtoCommand = func(ctx context.Context, proto ProtoExecConfig) ([]byte, error) {
+		return nil, newProtoError(proto.Args, exitCodeError{1}, "network unreachable", 0)
+	}
+
+	tools, err := getOutdatedStatus(context.Background(), ProtoConfig{})
+
+	var protoErr *ProtoError
+	if !errors.As(err, &protoErr) || protoErr.Stderr != "network unreachable" {
+		t.Errorf("getOutdatedStatus() error = %v, want ProtoError", err)
+	}
+	if tools == nil {
+		t.Error("getOutdatedStatus() should still return an empty map")
+	}
+}
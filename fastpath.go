@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxRenderAge is how long an unused render entry is kept on disk.
+const maxRenderAge = 24 * time.Hour
+
+// renderEntry is the last rendered prompt for one directory. It stays valid
+// while the config file and every .prototools path proto may read match
+// their fingerprints, and until the cache entry it was rendered from expires.
+type renderEntry struct {
+	Output  string            `json:"output"`
+	Expires int64             `json:"expires"`
+	Config  fileFingerprint   `json:"config"`
+	Files   []fileFingerprint `json:"files"`
+	Markers map[string]bool   `json:"markers,omitempty"` // Walk marker paths and whether they exist
+	Env     map[string]string `json:"env,omitempty"`     // Other variables the output depends on, e.g. COLUMNS
+}
+
+// fileFingerprint identifies a file by its metadata, without reading it.
+type fileFingerprint struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size,omitempty"`
+	ModTime int64  `json:"mtime,omitempty"` // Unix nanoseconds
+	Inode   uint64 `json:"inode,omitempty"`
+	Missing bool   `json:"missing,omitempty"`
+}
+
+func statFingerprint(path string) fileFingerprint {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileFingerprint{Path: path, Missing: true}
+	}
+	return fileFingerprint{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Inode:   fileInode(info),
+	}
+}
+
+// getRenderDir returns {config_name}.render in the directory of the cache
+// file. It holds one small file per render key, so a hit never parses the
+// whole cache.
+var getRenderDir = func() string {
+	cacheFile := getCacheFile()
+	configFile := getConfigFilePath()
+	if cacheFile == "" || configFile == "" {
+		return ""
+	}
+	configBase := filepath.Base(configFile)
+	configName := strings.TrimSuffix(configBase, filepath.Ext(configBase))
+	return filepath.Join(filepath.Dir(cacheFile), configName+".render")
+}
+
+// renderKey covers the inputs of a render that are not files: the working
+// directory, the config file, --offline and proto's environment variables.
+func renderKey(wd, configFile string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%t\x00", wd, configFile, offlineMode)
+
+	env := os.Environ()
+	sort.Strings(env)
+	for _, entry := range env {
+		if strings.HasPrefix(entry, "PROTO_") {
+			fmt.Fprintf(h, "%s\x00", entry)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func getRenderFile() string {
+	renderDir := getRenderDir()
+	if renderDir == "" {
+		return ""
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(renderDir, renderKey(wd, getConfigFilePath())+".json")
+}
+
+// readRenderCache returns the last rendered prompt for the current directory
+// if none of its inputs changed. Apart from reading the entry itself, it
+// only stats files.
+func readRenderCache() (string, bool) {
+	renderFile := getRenderFile()
+	if renderFile == "" {
+		return "", false
+	}
+
+	data, err := os.ReadFile(renderFile)
+	if err != nil {
+		return "", false
+	}
+
+	var entry renderEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return "", false
+	}
+
+	if time.Now().Unix() >= entry.Expires {
+		return "", false
+	}
+	if entry.Config.Missing || statFingerprint(entry.Config.Path) != entry.Config {
+		return "", false
+	}
+	for _, file := range entry.Files {
+		if statFingerprint(file.Path) != file {
+			return "", false
+		}
+	}
+	for path, exists := range entry.Markers {
+		if pathExists(path) != exists {
+			return "", false
+		}
+	}
+	for key, value := range entry.Env {
+		if os.Getenv(key) != value {
+			return "", false
+		}
+	}
+
+	return entry.Output, true
+}
+
+// fingerprintInputs stats the config file and the .prototools paths proto
+// may read for config, and adds the template files loaded with it, the
+// variables its templates read with env and $COLUMNS when the width depends
+// on it. A template reading a variable by a computed name is never stored.
+// Walk markers are recorded by existence only, as creating or removing one
+// moves the walk boundary while their contents, such as .git, change all
+// the time. It runs before rendering, so a file edited while proto is
+// queried invalidates the stored output.
+func fingerprintInputs(config ProtoConfig) (renderEntry, bool) {
+	configFile := getConfigFilePath()
+	if configFile == "" {
+		return renderEntry{}, false
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return renderEntry{}, false
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return renderEntry{}, false
+	}
+
+	entry := renderEntry{Config: statFingerprint(configFile)}
+	if entry.Config.Missing {
+		return renderEntry{}, false
+	}
+	for _, path := range prototoolsCandidatesForMode(wd, homeDir, config) {
+		entry.Files = append(entry.Files, statFingerprint(path))
+	}
+	entry.Files = append(entry.Files, config.templateSources...)
+	for _, path := range walkMarkerCandidates(wd, homeDir, config) {
+		if entry.Markers == nil {
+			entry.Markers = make(map[string]bool)
+		}
+		entry.Markers[path] = pathExists(path)
+	}
+
+	envVars, ok := templateEnvVars(config)
+	if !ok {
+		return renderEntry{}, false
+	}
+	if config.Display.MaxWidthRatio > 0 {
+		envVars = append(envVars, "COLUMNS")
+	}
+	for _, key := range envVars {
+		if entry.Env == nil {
+			entry.Env = make(map[string]string)
+		}
+		entry.Env[key] = os.Getenv(key)
+	}
+
+	return entry, true
+}
+
+// writeRenderCache stores output for the fingerprinted inputs until expires,
+// and removes entries that have not been written for maxRenderAge.
+func writeRenderCache(entry renderEntry, output string, expires int64) {
+	renderFile := getRenderFile()
+	if renderFile == "" {
+		return
+	}
+
+	entry.Output = output
+	entry.Expires = expires
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	renderDir := filepath.Dir(renderFile)
+	if err := os.MkdirAll(renderDir, 0755); err != nil {
+		return
+	}
+	pruneRenderCache(renderDir)
+
+	tmpFile := renderFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return
+	}
+	os.Rename(tmpFile, renderFile)
+}
+
+func pruneRenderCache(renderDir string) {
+	entries, err := os.ReadDir(renderDir)
+	if err != nil {
+		return
+	}
+	for _, dirEntry := range entries {
+		info, err := dirEntry.Info()
+		if err == nil && time.Since(info.ModTime()) > maxRenderAge {
+			os.Remove(filepath.Join(renderDir, dirEntry.Name()))
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetProtoStatusFastPath(t *testing.T) {
+	oldConfigPath := configPath
+	oldForceRefresh := forceRefresh
+	oldGetCacheFile := getCacheFile
+	oldLoadConfig := loadConfig
+	oldProtoInstalled := protoInstalled
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	defer func() {
+		configPath = oldConfigPath
+		forceRefresh = oldForceRefresh
+		getCacheFile = oldGetCacheFile
+		loadConfig = oldLoadConfig
+		protoInstalled = oldProtoInstalled
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+	}()
+
+	home := t.TempDir()
+	project := filepath.Join(home, "project")
+	os.MkdirAll(project, 0755)
+	t.Setenv("HOME", home)
+	t.Chdir(project)
+
+	prototoolsFile := filepath.Join(project, ".prototools")
+	os.WriteFile(prototoolsFile, []byte("node = \"22\"\n"), 0644)
+
+	configDir := t.TempDir()
+	configPath = filepath.Join(configDir, "config.jsonc")
+	os.WriteFile(configPath, []byte("{}"), 0644)
+	getCacheFile = func() string { return filepath.Join(configDir, "config.cache.json") }
+
+	loads := 0
+	loadConfig = func() (ProtoConfig, error) {
+		loads++
+		return ProtoConfig{Template: "{{.Tool}} {{.ResolvedVersion}}"}, nil
+	}
+	protoInstalled = func(config ProtoConfig) bool { return true }
+	version := "22.1.0"
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		return map[string]ToolStatus{"node": {IsInstalled: true, ResolvedVersion: version}}, nil
+	}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		return map[string]OutdatedStatus{"node": {}}, nil
+	}
+
+	render := func() string {
+		loads = 0
+		return getProtoStatus(context.Background())
+	}
+
+	if output := render(); output != "node 22.1.0" || loads != 1 {
+		t.Fatalf("first render = %q with %d config loads", output, loads)
+	}
+
+	version = "22.2.0"
+	if output := render(); output != "node 22.1.0" || loads != 0 {
+		t.Errorf("fast path = %q with %d config loads, want the stored output without loading the config", output, loads)
+	}
+
+	forceRefresh = true
+	if output := render(); output != "node 22.2.0" || loads != 1 {
+		t.Errorf("--refresh = %q with %d config loads, want a full render", output, loads)
+	}
+	forceRefresh = false
+
+	os.WriteFile(prototoolsFile, []byte("node = \"22.2\"\n"), 0644)
+	version = "22.3.0"
+	if output := render(); output != "node 22.3.0" || loads != 1 {
+		t.Errorf("after editing .prototools = %q with %d config loads, want a full render", output, loads)
+	}
+
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(configPath, later, later)
+	if _, ok := readRenderCache(); ok {
+		t.Error("fast path should miss after the config file changed")
+	}
+}
+
+func TestReadRenderCacheExpires(t *testing.T) {
+	oldConfigPath := configPath
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		configPath = oldConfigPath
+		getCacheFile = oldGetCacheFile
+	}()
+
+	t.Setenv("HOME", t.TempDir())
+	configDir := t.TempDir()
+	configPath = filepath.Join(configDir, "config.jsonc")
+	os.WriteFile(configPath, []byte("{}"), 0644)
+	getCacheFile = func() string { return filepath.Join(configDir, "config.cache.json") }
+
+	inputs, ok := fingerprintInputs(ProtoConfig{})
+	if !ok {
+		t.Fatal("fingerprintInputs() failed")
+	}
+
+	writeRenderCache(inputs, "expired", time.Now().Unix()-1)
+	if _, ok := readRenderCache(); ok {
+		t.Error("readRenderCache() returned an expired entry")
+	}
+
+	writeRenderCache(inputs, "fresh", time.Now().Unix()+60)
+	if output, ok := readRenderCache(); !ok || output != "fresh" {
+		t.Errorf("readRenderCache() = %q, %v", output, ok)
+	}
+
+	if !strings.HasSuffix(filepath.Dir(getRenderFile()), "config.render") {
+		t.Errorf("render file %s is not next to the cache", getRenderFile())
+	}
+}
+
+func TestReadRenderCacheChecksTemplateEnv(t *testing.T) {
+	oldConfigPath := configPath
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		configPath = oldConfigPath
+		getCacheFile = oldGetCacheFile
+	}()
+
+	t.Setenv("HOME", t.TempDir())
+	configDir := t.TempDir()
+	configPath = filepath.Join(configDir, "config.jsonc")
+	os.WriteFile(configPath, []byte("{}"), 0644)
+	getCacheFile = func() string { return filepath.Join(configDir, "config.cache.json") }
+
+	t.Setenv("SSH_TTY", "/dev/pts/1")
+	inputs, ok := fingerprintInputs(ProtoConfig{Template: "{{if env \"SSH_TTY\"}}ssh {{end}}{{.Tool}}"})
+	if !ok {
+		t.Fatal("fingerprintInputs() failed")
+	}
+	writeRenderCache(inputs, "ssh node", time.Now().Unix()+60)
+	if _, ok := readRenderCache(); !ok {
+		t.Fatal("readRenderCache() missed with the same $SSH_TTY")
+	}
+
+	t.Setenv("SSH_TTY", "")
+	if _, ok := readRenderCache(); ok {
+		t.Error("readRenderCache() should miss after $SSH_TTY changed")
+	}
+
+	if _, ok := fingerprintInputs(ProtoConfig{Template: "{{env .Tool}}"}); ok {
+		t.Error("fingerprintInputs() should refuse a template reading a computed variable")
+	}
+}
+
+func TestReadRenderCacheChecksWalkMarkers(t *testing.T) {
+	oldConfigPath := configPath
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		configPath = oldConfigPath
+		getCacheFile = oldGetCacheFile
+	}()
+
+	home := t.TempDir()
+	project := filepath.Join(home, "repo", "app")
+	os.MkdirAll(project, 0755)
+	os.WriteFile(filepath.Join(home, "repo", ".prototools"), []byte("node = \"22\"\n"), 0644)
+	t.Setenv("HOME", home)
+	t.Chdir(project)
+
+	configDir := t.TempDir()
+	configPath = filepath.Join(configDir, "config.jsonc")
+	os.WriteFile(configPath, []byte("{}"), 0644)
+	getCacheFile = func() string { return filepath.Join(configDir, "config.cache.json") }
+
+	config := ProtoConfig{Walk: WalkConfig{Markers: []string{".git"}}}
+	os.MkdirAll(filepath.Join(home, "repo", ".git"), 0755)
+	inputs, ok := fingerprintInputs(config)
+	if !ok {
+		t.Fatal("fingerprintInputs() failed")
+	}
+	writeRenderCache(inputs, "node 22", time.Now().Unix()+60)
+
+	os.WriteFile(filepath.Join(home, "repo", ".git", "index"), []byte("changed"), 0644)
+	if _, ok := readRenderCache(); !ok {
+		t.Fatal("readRenderCache() missed after a change inside the marker")
+	}
+
+	// git init in the working directory moves the walk boundary below the
+	// parent's .prototools.
+	os.Mkdir(filepath.Join(project, ".git"), 0755)
+	if _, ok := readRenderCache(); ok {
+		t.Error("readRenderCache() should miss after a walk marker was created")
+	}
+}
+
+func TestStageTimer(t *testing.T) {
+	var timer stageTimer
+	timer.mark("ignored")
+	if len(timer.stages) != 0 {
+		t.Error("mark() before begin() should do nothing")
+	}
+
+	timer.begin()
+	timer.mark("config")
+	timer.mark("render")
+
+	var out strings.Builder
+	timer.write(&out)
+	for _, stage := range []string{"config", "render", "total"} {
+		if !strings.Contains(out.String(), stage) {
+			t.Errorf("timings output is missing %s:\n%s", stage, out.String())
+		}
+	}
+}
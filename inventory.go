@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// protoManifest is the subset of $PROTO_HOME/tools/<tool>/manifest.json
+// needed to list installed versions.
+type protoManifest struct {
+	InstalledVersions []string `json:"installed_versions"`
+}
+
+type installedVersion struct {
+	version Version
+	dir     string
+}
+
+// listInstalledVersions returns the versions of tool with an install
+// directory under toolsDir. The manifest is consulted first; without one,
+// every directory named like a version counts.
+func listInstalledVersions(toolsDir, tool string) []installedVersion {
+	toolDir := filepath.Join(toolsDir, tool)
+
+	var names []string
+	if data, err := os.ReadFile(filepath.Join(toolDir, "manifest.json")); err == nil {
+		var manifest protoManifest
+		if json.Unmarshal(data, &manifest) == nil {
+			names = manifest.InstalledVersions
+		}
+	}
+	if names == nil {
+		entries, err := os.ReadDir(toolDir)
+		if err != nil {
+			return nil
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+
+	var installed []installedVersion
+	for _, name := range names {
+		v, ok := parseVersion(name)
+		if !ok || v.Parts != 3 {
+			continue
+		}
+		dir := filepath.Join(toolDir, name)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		installed = append(installed, installedVersion{version: v, dir: dir})
+	}
+	return installed
+}
+
+// resolveInstalled picks the highest installed version matching the pinned
+// requirement. ok is false when the requirement cannot be resolved locally,
+// such as an alias like "lts".
+func resolveInstalled(pin ToolStatus, installed []installedVersion) (ToolStatus, bool) {
+	status := pin
+
+	var best *installedVersion
+	for i := range installed {
+		match, err := satisfies(installed[i].version, pin.ConfigVersion)
+		if err != nil {
+			return ToolStatus{}, false
+		}
+		if match && (best == nil || compareVersions(installed[i].version, best.version) > 0) {
+			best = &installed[i]
+		}
+	}
+
+	if best == nil {
+		if err := validateRequirement(pin.ConfigVersion); err != nil {
+			return ToolStatus{}, false
+		}
+		if v, ok := parseVersion(pin.ConfigVersion); ok && v.Parts == 3 {
+			status.ResolvedVersion = v.String()
+		}
+		return status, true
+	}
+
+	status.IsInstalled = true
+	status.ResolvedVersion = filepath.Base(best.dir)
+	status.ProductDir = best.dir
+	return status, true
+}
+
+// protoVersionEnvVar returns the variable proto reads to override a tool's
+// version, e.g. PROTO_NODE_VERSION.
+func protoVersionEnvVar(tool string) string {
+	return "PROTO_" + strings.ToUpper(strings.ReplaceAll(tool, "-", "_")) + "_VERSION"
+}
+
+// readInventoryStatus computes the equivalent of `proto status` from the
+// .prototools pins and the proto home inventory. ok is false whenever the
+// answer could differ from proto's, and the caller should ask proto.
+var readInventoryStatus = func(config ProtoConfig) (map[string]ToolStatus, bool) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, false
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, false
+	}
+
+	pins, _, err := loadConfiguredVersions(wd, homeDir, config)
+	if err != nil || len(pins) == 0 {
+		return nil, false
+	}
+
+	toolsDir := filepath.Join(getProtoHome(config, homeDir), "tools")
+	tools := make(map[string]ToolStatus, len(pins))
+	for tool, pin := range pins {
+		// Plugin-backed tools and environment overrides are left to proto.
+		if strings.Contains(tool, ":") || lookupProtoEnv(config, protoVersionEnvVar(tool)) != "" {
+			return nil, false
+		}
+
+		status, ok := resolveInstalled(pin, listInstalledVersions(toolsDir, tool))
+		if !ok {
+			return nil, false
+		}
+		tools[tool] = status
+	}
+
+	return tools, true
+}
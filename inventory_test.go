@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupInventory creates a home directory with a project and a proto home
+// holding the given installed versions per tool.
+func setupInventory(t *testing.T, prototools string, installed map[string][]string) string {
+	t.Helper()
+
+	home := t.TempDir()
+	protoHome := filepath.Join(home, ".proto")
+	project := filepath.Join(home, "project")
+	os.MkdirAll(project, 0755)
+	os.WriteFile(filepath.Join(project, ".prototools"), []byte(prototools), 0644)
+
+	for tool, versions := range installed {
+		for _, version := range versions {
+			os.MkdirAll(filepath.Join(protoHome, "tools", tool, version), 0755)
+		}
+	}
+
+	t.Setenv("HOME", home)
+	t.Setenv("PROTO_HOME", protoHome)
+	t.Chdir(project)
+	return protoHome
+}
+
+func TestReadInventoryStatus(t *testing.T) {
+	protoHome := setupInventory(t, "node = \"~22\"\ngo = \"1.23.4\"\nbun = \"1\"\n", map[string][]string{
+		"node": {"20.12.2", "22.11.0", "22.12.0"},
+		"go":   {"1.22.0"},
+	})
+
+	tools, ok := readInventoryStatus(ProtoConfig{})
+	if !ok {
+		t.Fatal("readInventoryStatus() should resolve locally")
+	}
+
+	node := tools["node"]
+	if !node.IsInstalled || node.ResolvedVersion != "22.12.0" || node.ConfigVersion != "~22" {
+		t.Errorf("node = %+v", node)
+	}
+	if node.ProductDir != filepath.Join(protoHome, "tools", "node", "22.12.0") {
+		t.Errorf("node ProductDir = %s", node.ProductDir)
+	}
+
+	if goStatus := tools["go"]; goStatus.IsInstalled || goStatus.ResolvedVersion != "1.23.4" {
+		t.Errorf("go = %+v, want missing exact pin", goStatus)
+	}
+	if bun := tools["bun"]; bun.IsInstalled || bun.ResolvedVersion != "" {
+		t.Errorf("bun = %+v, want missing", bun)
+	}
+}
+
+func TestReadInventoryStatusManifest(t *testing.T) {
+	protoHome := setupInventory(t, "node = \"22\"\n", map[string][]string{
+		"node": {"22.11.0", "22.12.0"},
+	})
+
+	// A manifest that no longer lists a version wins over its leftover directory.
+	manifest := `{"installed_versions": ["22.11.0"]}`
+	os.WriteFile(filepath.Join(protoHome, "tools", "node", "manifest.json"), []byte(manifest), 0644)
+
+	tools, ok := readInventoryStatus(ProtoConfig{})
+	if !ok {
+		t.Fatal("readInventoryStatus() should resolve locally")
+	}
+	if tools["node"].ResolvedVersion != "22.11.0" {
+		t.Errorf("node = %+v, want manifest version", tools["node"])
+	}
+}
+
+func TestReadInventoryStatusAmbiguous(t *testing.T) {
+	tests := []struct {
+		name       string
+		prototools string
+		env        map[string]string
+	}{
+		{"alias", "node = \"lts\"\n", nil},
+		{"plugin backend", "\"npm:prettier\" = \"3\"\n", nil},
+		{"env override", "node = \"22\"\n", map[string]string{"PROTO_NODE_VERSION": "20"}},
+		{"no pins", "[settings]\nauto-install = true\n", nil},
+		{"parse error", "node = \n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupInventory(t, tt.prototools, map[string][]string{"node": {"22.12.0"}})
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			if tools, ok := readInventoryStatus(ProtoConfig{}); ok {
+				t.Errorf("readInventoryStatus() = %+v, want fallback to proto", tools)
+			}
+		})
+	}
+}
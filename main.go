@@ -2,17 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -32,10 +37,13 @@ const (
 )
 
 const (
-	defaultTemplate   = `{{.ToolIcon}} {{if .IsInstalled}}{{if eq .ResolvedVersion .LatestVersion}}{{fgColor "green"}}{{.ResolvedVersion}}{{reset}}{{else}}{{if eq .ResolvedVersion .NewestVersion}}{{fgColor "cyan"}}{{.ResolvedVersion}}{{reset}}{{else}}{{fgColor "#4a5568"}}{{.ResolvedVersion}}{{reset}} {{fgColor "white"}}→{{reset}} {{fgColor "cyan"}}{{.NewestVersion}}{{reset}}{{end}}{{end}}{{else}}{{fgColor "red"}}Missing{{reset}}{{end}}  `
+	defaultTemplate   = `{{.ToolIcon}} {{if .IsInstalled}}{{if .OutdatedUnknown}}{{.ResolvedVersion}}{{fgColor "#4a5568"}}?{{reset}}{{else}}{{if eq .ResolvedVersion .LatestVersion}}{{fgColor "green"}}{{.ResolvedVersion}}{{reset}}{{else}}{{if eq .ResolvedVersion .NewestVersion}}{{fgColor "cyan"}}{{.ResolvedVersion}}{{reset}}{{else}}{{fgColor "#4a5568"}}{{.ResolvedVersion}}{{reset}} {{fgColor "white"}}→{{reset}} {{fgColor "cyan"}}{{.NewestVersion}}{{reset}}{{end}}{{end}}{{end}}{{else}}{{fgColor "red"}}Missing{{reset}}{{end}}  `
 	defaultCacheTTL   = 300
 	defaultConfigMode = "upwards"
 	ResetColor        = "\x1b[0m"
+
+	defaultCommandTimeoutMs = 3000
+	defaultTotalTimeoutMs   = 5000
 )
 
 func getConfigMode(configMode string) string {
@@ -60,6 +68,10 @@ func getConfigModeFlags(configMode string) []string {
 var (
 	forceRefresh     bool
 	silentMode       bool
+	offlineMode      bool
+	doctorMode       bool
+	explainMode      bool
+	timingsMode      bool
 	configPath       string
 	cachedConfig     ProtoConfig
 	cachedConfigPath string
@@ -70,6 +82,10 @@ func init() {
 	flag.BoolVar(&forceRefresh, "refresh", false, "Bypass cache and fetch fresh data from proto")
 	flag.BoolVar(&silentMode, "silent", false, "Suppress output (useful for hooks/caching)")
 	flag.StringVar(&configPath, "config", "", "Path to custom config file (overrides default location)")
+	flag.BoolVar(&offlineMode, "offline", false, "Never query proto for outdated versions (also enabled by PROTO_OFFLINE)")
+	flag.BoolVar(&doctorMode, "doctor", false, "Check proto, config, template and cache, then exit")
+	flag.BoolVar(&explainMode, "explain", false, "Describe how the prompt for the current directory is produced")
+	flag.BoolVar(&timingsMode, "timings", false, "Print how long each stage of the prompt took to stderr")
 }
 
 type ToolStatus struct {
@@ -91,49 +107,147 @@ type OutdatedStatus struct {
 }
 
 type IconConfig struct {
-	Icon  string `json:"icon"`
-	Color string `json:"color"`
+	Icon     string `json:"icon"`
+	Color    string `json:"color"`
+	Label    string `json:"label,omitempty"`    // Display name, defaults to the tool name
+	Hidden   bool   `json:"hidden,omitempty"`   // Leave the tool out of the output
+	Order    int    `json:"order,omitempty"`    // Position; tools with an order come first, the rest by sort
+	Template string `json:"template,omitempty"` // Replaces the top-level template for this tool
 }
 
 type CacheConfig struct {
 	TTL int `json:"ttl,omitempty"` // Cache TTL in seconds, default 300 (5 min)
 }
 
+type TimeoutConfig struct {
+	CommandMs int `json:"command_ms,omitempty"` // Per proto invocation, default 3000
+	TotalMs   int `json:"total_ms,omitempty"`   // Whole fetch on a cache miss, default 5000
+}
+
+type WalkConfig struct {
+	Markers  []string `json:"markers,omitempty"`   // Stop at a directory containing one of these, e.g. ".git"
+	MaxDepth int      `json:"max_depth,omitempty"` // Parent directories searched above the working directory, 0 is unlimited
+}
+
+type DisplayConfig struct {
+	HideWhen        []string `json:"hide_when,omitempty"`         // Hide tools in these states, e.g. ["latest"]
+	ShowOnlySources []string `json:"show_only_sources,omitempty"` // Only show tools pinned in these sources: local, inherited, global
+	HideGlobal      bool     `json:"hide_global,omitempty"`       // Hide tools pinned in $PROTO_HOME/.prototools
+	MaxWidth        int      `json:"max_width,omitempty"`         // Widest segment in columns, 0 is unlimited
+	MaxWidthRatio   float64  `json:"max_width_ratio,omitempty"`   // Widest segment as a fraction of $COLUMNS, e.g. 0.3
+	Overflow        string   `json:"overflow,omitempty"`          // Appended when tools are dropped for width, default " +{count}"
+	IconWidth       int      `json:"icon_width,omitempty"`        // Columns of a Nerd Font icon, default 1
+}
+
+type ProtoExecConfig struct {
+	Path string            `json:"path,omitempty"` // Executable, default "proto" from PATH
+	Args []string          `json:"args,omitempty"` // Global arguments placed before every command
+	Env  map[string]string `json:"env,omitempty"`  // Environment overrides, e.g. PROTO_HOME
+}
+
 type DirectoryCacheData struct {
 	StatusData   map[string]ToolStatus     `json:"status"`
 	OutdatedData map[string]OutdatedStatus `json:"outdated"`
 	Timestamp    int64                     `json:"timestamp"`
+	StatusOnly   bool                      `json:"status_only,omitempty"`   // Written offline; OutdatedData is from an earlier online fetch
+	Errors       []ProtoError              `json:"errors,omitempty"`        // Failures of the last fetch
+	RenderErrors []RenderError             `json:"render_errors,omitempty"` // Tools dropped by the last failed render
 }
 
 type CachedData struct {
-	Entries map[string]DirectoryCacheData `json:"entries"` // Keyed by directory hash
+	Entries       map[string]DirectoryCacheData `json:"entries"`                  // Keyed by directory hash
+	ProtoVersions map[string]cachedProtoVersion `json:"proto_versions,omitempty"` // Keyed by proto executable path
 }
 
 type CachedResult struct {
 	StatusData   map[string]ToolStatus
 	OutdatedData map[string]OutdatedStatus
+	Timestamp    int64
+	StatusOnly   bool
 }
 
 type ProtoConfig struct {
-	ConfigMode string                `json:"config_mode,omitempty"` // global, local, upwards (default), upwards-global
-	Tools      map[string]IconConfig `json:"tools"`
-	Template   string                `json:"template,omitempty"`
-	Cache      CacheConfig           `json:"cache,omitzero"`
+	ConfigMode     string                `json:"config_mode,omitempty"` // global, local, upwards (default), upwards-global
+	Tools          map[string]IconConfig `json:"tools"`
+	Backends       map[string]IconConfig `json:"backends,omitempty"` // Icon defaults for plugin backends, e.g. asdf
+	Template       string                `json:"template,omitempty"`
+	TemplateFile   string                `json:"template_file,omitempty"`   // Read into Template, relative to the config file
+	Templates      map[string]string     `json:"templates,omitempty"`       // Named partials for {{template "name" .}}
+	LayoutTemplate string                `json:"layout_template,omitempty"` // Renders all tools at once; "template" becomes the "tool" partial
+	ErrorTemplate  string                `json:"error_template,omitempty"`  // Rendered instead of an empty segment on failure
+	States         map[string]StateStyle `json:"states,omitempty"`          // Styles by tool state, used when template is empty or the default
+	Display        DisplayConfig         `json:"display,omitzero"`
+	Cache          CacheConfig           `json:"cache,omitzero"`
+	Timeout        TimeoutConfig         `json:"timeout,omitzero"`
+	Walk           WalkConfig            `json:"walk,omitzero"`
+	Sort           string                `json:"sort,omitempty"`             // alphabetical (default), config, prototools, attention or source
+	PromptBudgetMs int                   `json:"prompt_budget_ms,omitempty"` // Wait for outdated data before rendering status only, 0 waits
+	Offline        bool                  `json:"offline,omitempty"`          // Skip outdated queries, which hit the network
+	Proto          ProtoExecConfig       `json:"proto,omitzero"`
+
+	partials        *template.Template // Parsed partials, see loadTemplates
+	templateSources []fileFingerprint  // Template files read by loadTemplates
+	toolOrder       []string           // Keys of "tools" in the order they are written
 }
 
 type TemplateData struct {
-	Tool            string
-	ToolIcon        string
-	IsInstalled     bool
-	ResolvedVersion string
-	IsLatest        bool
-	IsOutdated      bool
-	ConfigVersion   string
-	NewestVersion   string
-	LatestVersion   string
-}
-
-var getDirectoryContext = func(configMode string) (string, error) {
+	Tool             string
+	Backend          string // Plugin backend of the tool ID, e.g. "asdf" in "asdf:terraform"
+	ToolName         string // Tool ID without the backend
+	Label            string // Configured label, defaults to ToolName
+	ToolIcon         string
+	IsInstalled      bool
+	ResolvedVersion  string
+	IsLatest         bool
+	IsOutdated       bool
+	ConfigVersion    string
+	NewestVersion    string
+	LatestVersion    string
+	OutdatedPending  bool
+	OutdatedUnknown  bool
+	OutdatedError    string
+	UpdateType       string // Update from ResolvedVersion to NewestVersion: major, minor, patch, prerelease or none
+	LatestUpdateType string // Update from ResolvedVersion to LatestVersion
+	MajorsBehind     int    // Major versions between ResolvedVersion and LatestVersion
+	ProtoEnv         string // Active PROTO_ENV, e.g. "production"
+	ConfigSource     string // .prototools file the tool is pinned in
+	SourceRelative   string // ConfigSource relative to the working directory
+	IsLocal          bool   // Pinned in the working directory
+	IsInherited      bool   // Pinned in a parent directory
+	IsGlobal         bool   // Pinned in $PROTO_HOME
+	ProductDir       string // Install directory of the resolved version
+	Index            int    // Position in the output, starting at 0
+	IsFirst          bool
+	IsLast           bool
+	State            string // missing, unknown, latest, newest or outdated
+	Output           string // The tool rendered with its template, for layout_template
+}
+
+// LayoutData is passed to layout_template.
+type LayoutData struct {
+	Tools          []TemplateData
+	Count          int
+	InstalledCount int
+	MissingCount   int
+	OutdatedCount  int
+}
+
+// ErrorData is passed to error_template when the segment cannot be rendered.
+type ErrorData struct {
+	Stage    string // config, proto, template, status or render
+	Message  string
+	Command  string // Failed proto command, empty for other stages
+	ExitCode int    // Exit code of the failed proto command, -1 if it did not exit
+}
+
+// FetchInfo describes how complete the data handed to formatOutput is.
+type FetchInfo struct {
+	OutdatedPending bool        // Outdated query is still running in the background
+	OutdatedUnknown bool        // Outdated data was not requested, e.g. in offline mode
+	OutdatedError   *ProtoError // Outdated query failed
+}
+
+var getDirectoryContext = func(config ProtoConfig) (string, error) {
 	wd, err := os.Getwd()
 	if err != nil {
 		return "", err
@@ -146,31 +260,71 @@ var getDirectoryContext = func(configMode string) (string, error) {
 
 	h := sha256.New()
 	h.Write([]byte(wd))
-	normalizedMode := getConfigMode(configMode)
+	normalizedMode := getConfigMode(config.ConfigMode)
 	h.Write([]byte(normalizedMode))
 
-	dir := wd
-	for {
-		prototoolsPath := filepath.Join(dir, ".prototools")
-		if info, err := os.Stat(prototoolsPath); err == nil && !info.IsDir() {
-			data, err := os.ReadFile(prototoolsPath)
-			if err == nil {
-				h.Write(data)
-			}
+	// Another proto binary, its global arguments or environment overrides
+	// can report different data for the same directory.
+	h.Write([]byte{0})
+	h.Write([]byte(resolveProtoPath(config)))
+	for _, arg := range config.Proto.Args {
+		h.Write([]byte{0})
+		h.Write([]byte(arg))
+	}
+	envKeys := make([]string, 0, len(config.Proto.Env))
+	for key := range config.Proto.Env {
+		envKeys = append(envKeys, key)
+	}
+	sort.Strings(envKeys)
+	for _, key := range envKeys {
+		h.Write([]byte{0})
+		h.Write([]byte(key + "=" + config.Proto.Env[key]))
+	}
+
+	// Only the files proto reads for the config mode, including the global
+	// $PROTO_HOME/.prototools for global, upwards-global and all.
+	for _, prototoolsPath := range prototoolsFilesForMode(wd, homeDir, config) {
+		data, err := os.ReadFile(prototoolsPath)
+		if err == nil {
+			h.Write(data)
 		}
+	}
 
-		if dir == homeDir || dir == "/" {
-			break
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// walkDirs returns dir and its parents up to homeDir, the filesystem root
+// or a walk boundary, nearest first.
+func walkDirs(dir, homeDir string, walk WalkConfig) (dirs []string, stop string) {
+	for depth := 0; ; depth++ {
+		dirs = append(dirs, dir)
+
+		if dir == homeDir {
+			return dirs, "home directory " + dir
+		}
+		if marker := findWalkMarker(dir, walk.Markers); marker != "" {
+			return dirs, fmt.Sprintf("marker %s in %s", marker, dir)
+		}
+		if walk.MaxDepth > 0 && depth >= walk.MaxDepth {
+			return dirs, fmt.Sprintf("max depth %d at %s", walk.MaxDepth, dir)
 		}
 
 		parent := filepath.Dir(dir)
 		if parent == dir {
-			break
+			return dirs, "filesystem root"
 		}
 		dir = parent
 	}
+}
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+// findWalkMarker returns the first marker that exists in dir.
+func findWalkMarker(dir string, markers []string) string {
+	for _, marker := range markers {
+		if pathExists(filepath.Join(dir, marker)) {
+			return marker
+		}
+	}
+	return ""
 }
 
 var getConfigFilePath = func() string {
@@ -228,7 +382,25 @@ func writeCache(cached CachedData) error {
 		return err
 	}
 
-	return os.WriteFile(cacheFile, data, 0644)
+	// The prompt and a background refresher may write at the same time;
+	// a rename keeps readers from seeing a partly written file.
+	tmpFile, err := os.CreateTemp(filepath.Dir(cacheFile), filepath.Base(cacheFile)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Chmod(0644); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), cacheFile)
 }
 
 func isCacheValid(cached CachedData) bool {
@@ -246,133 +418,489 @@ func isCacheEntryValid(entry DirectoryCacheData, ttlSeconds int) bool {
 	return elapsed.Seconds() < float64(ttlSeconds)
 }
 
-func getCachedData(config ProtoConfig, configMode string) (CachedResult, bool) {
+func getCachedData(config ProtoConfig) (CachedResult, bool) {
 	if forceRefresh {
 		return CachedResult{}, false
 	}
 
-	ttl := config.Cache.TTL
-	if ttl == 0 {
-		ttl = defaultCacheTTL
-	}
-
-	cached, err := readCache()
-	if err != nil || !isCacheValid(cached) {
+	entry, ok := lookupCacheEntry(config)
+	if !ok || !isCacheEntryValid(entry, getCacheTTL(config)) {
 		return CachedResult{}, false
 	}
-
-	dirHash, err := getDirectoryContext(configMode)
-	if err != nil {
+	// A status-only entry lacks fresh outdated data, which online prompts
+	// must fetch.
+	if entry.StatusOnly && !isOffline(config) {
 		return CachedResult{}, false
 	}
 
-	entry, exists := cached.Entries[dirHash]
-	if !exists || !isCacheEntryValid(entry, ttl) {
+	return CachedResult{
+		StatusData:   entry.StatusData,
+		OutdatedData: entry.OutdatedData,
+		Timestamp:    entry.Timestamp,
+		StatusOnly:   entry.StatusOnly,
+	}, true
+}
+
+// getStaleCachedData returns the cache entry for the current directory
+// regardless of its age. It is the fallback when proto cannot answer
+// within the time budget.
+func getStaleCachedData(config ProtoConfig) (CachedResult, bool) {
+	entry, ok := lookupCacheEntry(config)
+	if !ok || entry.StatusData == nil {
 		return CachedResult{}, false
 	}
 
 	return CachedResult{
 		StatusData:   entry.StatusData,
 		OutdatedData: entry.OutdatedData,
+		Timestamp:    entry.Timestamp,
+		StatusOnly:   entry.StatusOnly,
 	}, true
 }
 
+func getCacheTTL(config ProtoConfig) int {
+	if config.Cache.TTL == 0 {
+		return defaultCacheTTL
+	}
+	return config.Cache.TTL
+}
+
+func lookupCacheEntry(config ProtoConfig) (DirectoryCacheData, bool) {
+	cached, err := readCache()
+	if err != nil || !isCacheValid(cached) {
+		return DirectoryCacheData{}, false
+	}
+
+	dirHash, err := getDirectoryContext(config)
+	if err != nil {
+		return DirectoryCacheData{}, false
+	}
+
+	entry, exists := cached.Entries[dirHash]
+	return entry, exists
+}
+
+// isOffline reports whether outdated queries are disabled by --offline, the
+// offline config key or proto's own PROTO_OFFLINE variable.
+func isOffline(config ProtoConfig) bool {
+	if offlineMode || config.Offline {
+		return true
+	}
+	offline, err := strconv.ParseBool(lookupProtoEnv(config, "PROTO_OFFLINE"))
+	return err == nil && offline
+}
+
+func getCommandTimeout(config ProtoConfig) time.Duration {
+	ms := config.Timeout.CommandMs
+	if ms <= 0 {
+		ms = defaultCommandTimeoutMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getTotalTimeout(config ProtoConfig) time.Duration {
+	ms := config.Timeout.TotalMs
+	if ms <= 0 {
+		ms = defaultTotalTimeoutMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
 func main() {
 	flag.Parse()
-	output := getProtoStatus()
+
+	// Cancel in-flight proto invocations on Ctrl-C so the prompt is never
+	// held hostage by a hung child process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch {
+	case doctorMode:
+		exitCode := runDoctor(ctx, os.Stdout)
+		stop()
+		os.Exit(exitCode)
+	case explainMode:
+		exitCode := runExplain(ctx, os.Stdout)
+		stop()
+		os.Exit(exitCode)
+	}
+
+	if timingsMode {
+		promptTimings.begin()
+	}
+
+	output := getProtoStatus(ctx)
 	if !silentMode {
 		fmt.Print(output)
 	}
+	if timingsMode {
+		promptTimings.write(os.Stderr)
+	}
 }
 
-func getProtoStatus() string {
-	if !protoInstalled() {
-		return ""
+func getProtoStatus(ctx context.Context) (output string) {
+	var config ProtoConfig
+
+	// A panic must never put a stack trace into the prompt.
+	defer func() {
+		if r := recover(); r != nil {
+			renderErr := recoverRenderError("", r)
+			recordRenderErrors(config, []RenderError{renderErr})
+			output = renderError(config, "render", errors.New(renderErr.Message))
+		}
+	}()
+
+	if !forceRefresh {
+		if output, ok := readRenderCache(); ok {
+			promptTimings.mark("fast path hit")
+			return output
+		}
+		promptTimings.mark("fast path miss")
 	}
 
 	config, err := loadConfig()
 	if err != nil {
-		return ""
+		return renderError(config, "config", err)
+	}
+	promptTimings.mark("config")
+
+	if !protoInstalled(config) {
+		return renderError(config, "proto", fmt.Errorf("%s not found", resolveProtoPath(config)))
+	}
+
+	if config.Template != "" {
+		if err := validateTemplate(config, config.Template); err != nil {
+			return renderError(config, "template", err)
+		}
+	}
+	if config.LayoutTemplate != "" {
+		if err := validateTemplate(config, config.LayoutTemplate, "tool"); err != nil {
+			return renderError(config, "template", err)
+		}
 	}
 
 	var (
 		tools         map[string]ToolStatus
 		outdatedTools map[string]OutdatedStatus
+		info          FetchInfo
 		toolsErr      error
 	)
 
-	type result struct {
-		status   map[string]ToolStatus
-		outdated map[string]OutdatedStatus
-		err      error
+	// The refresher a spent prompt budget starts is a --refresh --silent
+	// run. Only one at a time queries proto and writes the cache, so
+	// repeated prompts on a slow network do not stack them up.
+	if forceRefresh && silentMode {
+		release, ok := acquireRefreshLock(config)
+		if !ok {
+			return ""
+		}
+		defer release()
 	}
 
-	resultChan := make(chan result, 1)
+	inputs, fingerprinted := fingerprintInputs(config)
 
-	cached, ok := getCachedData(config, config.ConfigMode)
+	cached, ok := getCachedData(config)
 	if ok {
-		close(resultChan)
 		tools = cached.StatusData
 		outdatedTools = cached.OutdatedData
+		info.OutdatedUnknown = cached.StatusOnly && outdatedTools == nil
+		promptTimings.mark("cache")
 	} else {
-		go func() {
-			var r result
+		tools, outdatedTools, info, toolsErr = fetchProtoData(ctx, config)
+		promptTimings.mark("proto")
+	}
 
-			type statusResult struct {
-				data map[string]ToolStatus
-				err  error
-			}
-			statusChan := make(chan statusResult, 1)
-			go func() {
-				data, err := getToolStatus(config)
-				statusChan <- statusResult{data, err}
-			}()
-
-			type outdatedResult struct {
-				data map[string]OutdatedStatus
+	if toolsErr != nil {
+		return renderError(config, "status", toolsErr)
+	}
+
+	output = formatOutput(tools, outdatedTools, config, info)
+	promptTimings.mark("render")
+
+	// Only output rendered from complete, cached data is reused; anything
+	// partial must be rendered again once the cache has caught up. Offline,
+	// status data is all there is, and the render key tells the modes apart.
+	complete := !info.OutdatedPending && (!info.OutdatedUnknown || isOffline(config)) && info.OutdatedError == nil
+	if fingerprinted && complete {
+		if !ok {
+			if entry, found := lookupCacheEntry(config); found && isCacheEntryValid(entry, getCacheTTL(config)) {
+				cached, ok = CachedResult{Timestamp: entry.Timestamp}, true
 			}
-			outdatedChan := make(chan outdatedResult, 1)
-			go func() {
-				data := getOutdatedStatus(config)
-				outdatedChan <- outdatedResult{data}
-			}()
+		}
+		if ok {
+			writeRenderCache(inputs, output, cached.Timestamp+int64(getCacheTTL(config)))
+		}
+	}
+
+	return output
+}
+
+// startBackgroundRefresh runs this binary again with --refresh --silent in
+// a new session and without inherited stdio, so it fills the cache after
+// the prompt has been rendered without holding the shell.
+var startBackgroundRefresh = func() error {
+	cmd, err := refreshCommand()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func refreshCommand() (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--refresh", "--silent"}
+	if configPath != "" {
+		args = append(args, "--config", configPath)
+	}
+	cmd := exec.Command(executable, args...)
+	detachProcess(cmd)
+	return cmd, nil
+}
 
-			sRes := <-statusChan
-			r.status = sRes.data
-			r.err = sRes.err
+// getRefreshLockFile returns the file a --refresh --silent run holds while
+// it queries proto and writes the cache.
+func getRefreshLockFile() string {
+	cacheFile := getCacheFile()
+	if cacheFile == "" {
+		return ""
+	}
+	return cacheFile + ".lock"
+}
 
-			oRes := <-outdatedChan
-			r.outdated = oRes.data
+// refreshRunning reports whether a refresher holds the lock. The refresher
+// is bounded by the total timeout, so an older lock was left behind by one
+// that died.
+func refreshRunning(config ProtoConfig) bool {
+	info, err := os.Stat(getRefreshLockFile())
+	return err == nil && time.Since(info.ModTime()) < 2*getTotalTimeout(config)
+}
 
-			resultChan <- r
+// acquireRefreshLock takes the refresh lock, replacing a stale one. It
+// fails only when another refresher holds the lock; if the lock cannot be
+// created at all, the refresh runs unlocked rather than never.
+func acquireRefreshLock(config ProtoConfig) (release func(), ok bool) {
+	lockFile := getRefreshLockFile()
+	if lockFile == "" {
+		return func() {}, true
+	}
+	if !refreshRunning(config) {
+		os.Remove(lockFile)
+	}
+
+	file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, false
+	}
+	if err != nil {
+		return func() {}, true
+	}
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	file.Close()
+	return func() { os.Remove(lockFile) }, true
+}
+
+// fetchProtoData queries proto for status and outdated data concurrently,
+// bounded by the total timeout. With a prompt budget configured, it returns
+// status-only data once the budget is spent: the outdated query is
+// cancelled, and unless one is already running, a detached refresher is
+// started to query proto again and write the cache.
+func fetchProtoData(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, map[string]OutdatedStatus, FetchInfo, error) {
+	var (
+		tools         map[string]ToolStatus
+		outdatedTools map[string]OutdatedStatus
+		info          FetchInfo
+		toolsErr      error
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, getTotalTimeout(config))
+
+	offline := isOffline(config)
+	info.OutdatedUnknown = offline
+
+	type statusResult struct {
+		data map[string]ToolStatus
+		err  error
+	}
+	statusChan := make(chan statusResult, 1)
+	go func() {
+		data, err := getToolStatus(ctx, config)
+		statusChan <- statusResult{data, err}
+	}()
+
+	type outdatedResult struct {
+		data map[string]OutdatedStatus
+		err  error
+	}
+	outdatedChan := make(chan outdatedResult, 1)
+	if !offline {
+		go func() {
+			data, err := getOutdatedStatus(ctx, config)
+			outdatedChan <- outdatedResult{data, err}
 		}()
+	}
 
-		r := <-resultChan
-		tools = r.status
-		outdatedTools = r.outdated
-		toolsErr = r.err
+	// The budget only matters when a prompt waits for the output; the
+	// --silent refresher it starts always waits for both queries.
+	var budget <-chan time.Time
+	if config.PromptBudgetMs > 0 && !silentMode {
+		timer := time.NewTimer(time.Duration(config.PromptBudgetMs) * time.Millisecond)
+		defer timer.Stop()
+		budget = timer.C
+	}
 
-		if toolsErr == nil && (len(tools) > 0 || len(outdatedTools) > 0) {
-			updateCache(tools, outdatedTools, config.ConfigMode)
+	statusDone, outdatedDone, budgetSpent := false, offline, false
+wait:
+	for !statusDone || !outdatedDone {
+		if budgetSpent && statusDone && toolsErr == nil {
+			info.OutdatedPending = true
+			break
+		}
+		select {
+		case r := <-statusChan:
+			tools, toolsErr = r.data, r.err
+			statusDone = true
+		case r := <-outdatedChan:
+			outdatedTools = r.data
+			if r.err != nil {
+				info.OutdatedError = asProtoError(r.err)
+			}
+			outdatedDone = true
+		case <-budget:
+			budgetSpent = true
+		case <-ctx.Done():
+			break wait
 		}
 	}
 
+	if info.OutdatedPending {
+		// The shell waits for this process to exit, not just for its
+		// output, so the outdated query is handed to a detached refresher
+		// instead of finishing here.
+		cancel()
+		<-outdatedChan
+		if refreshRunning(config) {
+			return tools, nil, info, nil
+		}
+		if err := startBackgroundRefresh(); err != nil {
+			recordProtoErrors(config, []*ProtoError{newProtoError(nil, fmt.Errorf("background refresh: %w", err), "", 0)})
+		}
+		return tools, nil, info, nil
+	}
+
+	// Both queries honor ctx, so cancelling and draining them leaves no
+	// goroutine behind once this returns.
+	timedOut := ctx.Err() != nil
+	cancel()
+	if !statusDone {
+		<-statusChan
+	}
+	if !outdatedDone {
+		<-outdatedChan
+	}
+
 	if toolsErr != nil {
-		return ""
+		toolsErr = asProtoError(toolsErr)
 	}
 
-	if len(tools) == 0 {
-		return ""
+	if timedOut {
+		timeoutErr := newProtoError(nil, fmt.Errorf("time budget of %s exceeded", getTotalTimeout(config)), "", getTotalTimeout(config))
+		recordProtoErrors(config, collectProtoErrors(toolsErr, info.OutdatedError, timeoutErr))
+
+		// Out of time: prefer whatever the last good fetch left behind,
+		// then fall back to rendering status data without outdated info.
+		if stale, ok := getStaleCachedData(config); ok {
+			info.OutdatedUnknown = info.OutdatedUnknown || (stale.StatusOnly && stale.OutdatedData == nil)
+			return stale.StatusData, stale.OutdatedData, info, nil
+		}
+		if !statusDone {
+			return nil, nil, info, timeoutErr
+		}
+		return tools, outdatedTools, info, toolsErr
+	}
+
+	// Offline results lack outdated data, so they are cached as status-only
+	// next to the outdated data of the last online fetch, if any. A failed
+	// outdated query keeps the last good entry, which the stale fallback
+	// relies on; only the error is recorded.
+	if offline {
+		if toolsErr == nil && len(tools) > 0 {
+			outdatedTools = updateStatusCache(tools, config)
+			info.OutdatedUnknown = outdatedTools == nil
+		}
+	} else if toolsErr == nil && info.OutdatedError == nil && (len(tools) > 0 || len(outdatedTools) > 0) {
+		updateCache(tools, outdatedTools, config)
 	}
+	recordProtoErrors(config, collectProtoErrors(toolsErr, info.OutdatedError))
+
+	return tools, outdatedTools, info, toolsErr
+}
 
-	return formatOutput(tools, outdatedTools, config)
+// asProtoError converts an error from a proto query into a ProtoError.
+func asProtoError(err error) *ProtoError {
+	return newProtoError(nil, err, "", 0)
 }
 
-var protoInstalled = func() bool {
-	_, err := exec.LookPath("proto")
+func collectProtoErrors(errs ...error) []*ProtoError {
+	var collected []*ProtoError
+	for _, err := range errs {
+		var protoErr *ProtoError
+		if errors.As(err, &protoErr) && protoErr != nil {
+			collected = append(collected, protoErr)
+		}
+	}
+	return collected
+}
+
+var protoInstalled = func(config ProtoConfig) bool {
+	_, err := exec.LookPath(resolveProtoPath(config))
 	return err == nil
 }
 
+// resolveProtoPath returns the proto executable to run. "~" and environment
+// variables in proto.path are expanded, and relative paths containing a
+// separator are taken relative to the config file.
+func resolveProtoPath(config ProtoConfig) string {
+	path := config.Proto.Path
+	if path == "" {
+		return "proto"
+	}
+
+	path = expandPath(path)
+	if !filepath.IsAbs(path) && strings.ContainsRune(path, filepath.Separator) {
+		if configFile := getConfigFilePath(); configFile != "" {
+			path = filepath.Join(filepath.Dir(configFile), path)
+		}
+	}
+	return path
+}
+
+// expandPath expands environment variables and a leading "~" in a path
+// from the config.
+func expandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(homeDir, path[1:])
+		}
+	}
+	return path
+}
+
+// lookupProtoEnv returns a variable as proto will see it: the proto.env
+// override when set, otherwise the inherited environment.
+func lookupProtoEnv(config ProtoConfig, key string) string {
+	if value, ok := config.Proto.Env[key]; ok {
+		return value
+	}
+	return os.Getenv(key)
+}
+
 var loadConfig = func() (ProtoConfig, error) {
 	// Determine config file path
 	var configFile string
@@ -393,7 +921,7 @@ var loadConfig = func() (ProtoConfig, error) {
 	if len(cachedConfig.Tools) > 0 || cachedConfig.Template != "" {
 		if cachedConfigPath == configFile {
 			if info, err := os.Stat(configFile); err == nil {
-				if info.ModTime().Equal(cachedConfigMod) || info.ModTime().Before(cachedConfigMod) {
+				if (info.ModTime().Equal(cachedConfigMod) || info.ModTime().Before(cachedConfigMod)) && !templateSourcesChanged(cachedConfig) {
 					return cachedConfig, nil
 				}
 			}
@@ -403,7 +931,12 @@ var loadConfig = func() (ProtoConfig, error) {
 	// Load fresh config
 	config, err := loadJSONConfig(configFile)
 	if err != nil {
-		return ProtoConfig{}, err
+		// Keep what was decoded, so error_template can still be used
+		// when only a single value has the wrong type.
+		return config, err
+	}
+	if err := loadTemplates(&config, configFile); err != nil {
+		return config, err
 	}
 
 	// Cache the config
@@ -416,65 +949,62 @@ var loadConfig = func() (ProtoConfig, error) {
 	return config, nil
 }
 
-var getToolStatus = func(config ProtoConfig) (map[string]ToolStatus, error) {
-	cached, ok := getCachedData(config, config.ConfigMode)
+var getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+	cached, ok := getCachedData(config)
 	if ok {
 		if cached.StatusData != nil {
 			return cached.StatusData, nil
 		}
 	}
 
-	args := []string{"status", "--json"}
-	if flags := getConfigModeFlags(config.ConfigMode); len(flags) > 0 {
-		args = append(args, flags...)
+	if tools, ok := readInventoryStatus(config); ok {
+		return tools, nil
 	}
 
-	output, err := runProtoCommand(args)
+	args := protoJSONArgs("status", config)
+
+	output, err := runProto(ctx, config, args)
 	if err != nil {
 		return nil, err
 	}
 
-	var tools map[string]ToolStatus
-	if err := json.Unmarshal(output, &tools); err != nil {
-		return nil, err
+	tools, err := protoSchema.DecodeStatus(output)
+	if err != nil {
+		return nil, newProtoError(args, err, "", 0)
 	}
-
 	return tools, nil
 }
 
-var getOutdatedStatus = func(config ProtoConfig) map[string]OutdatedStatus {
-	cached, ok := getCachedData(config, config.ConfigMode)
+var getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+	cached, ok := getCachedData(config)
 	if ok {
 		if cached.OutdatedData != nil {
-			return cached.OutdatedData
+			return cached.OutdatedData, nil
 		}
 	}
 
-	args := []string{"outdated", "--json"}
-	if flags := getConfigModeFlags(config.ConfigMode); len(flags) > 0 {
-		args = append(args, flags...)
-	}
+	args := protoJSONArgs("outdated", config)
 
-	output, err := runProtoCommand(args)
+	output, err := runProto(ctx, config, args)
 	if err != nil {
-		return make(map[string]OutdatedStatus)
+		return make(map[string]OutdatedStatus), err
 	}
 
-	var tools map[string]OutdatedStatus
-	if err := json.Unmarshal(output, &tools); err != nil {
-		return make(map[string]OutdatedStatus)
+	tools, err := protoSchema.DecodeOutdated(output)
+	if err != nil {
+		return make(map[string]OutdatedStatus), newProtoError(args, err, "", 0)
 	}
 
-	return tools
+	return tools, nil
 }
 
-func updateCache(statusData map[string]ToolStatus, outdatedData map[string]OutdatedStatus, configMode string) {
+func updateCache(statusData map[string]ToolStatus, outdatedData map[string]OutdatedStatus, config ProtoConfig) {
 	cached, _ := readCache()
 	if cached.Entries == nil {
 		cached.Entries = make(map[string]DirectoryCacheData)
 	}
 
-	dirHash, err := getDirectoryContext(configMode)
+	dirHash, err := getDirectoryContext(config)
 	if err != nil {
 		return
 	}
@@ -488,27 +1018,103 @@ func updateCache(statusData map[string]ToolStatus, outdatedData map[string]Outda
 	writeCache(cached)
 }
 
-var runProtoCommand = func(args []string) ([]byte, error) {
-	cmd := exec.Command("proto", args...)
-	return cmd.Output()
+// updateStatusCache stores status data fetched offline as a status-only
+// entry, keeping the outdated data of the existing entry, and returns it.
+func updateStatusCache(statusData map[string]ToolStatus, config ProtoConfig) map[string]OutdatedStatus {
+	cached, _ := readCache()
+	if cached.Entries == nil {
+		cached.Entries = make(map[string]DirectoryCacheData)
+	}
+
+	dirHash, err := getDirectoryContext(config)
+	if err != nil {
+		return nil
+	}
+
+	outdatedData := cached.Entries[dirHash].OutdatedData
+	cached.Entries[dirHash] = DirectoryCacheData{
+		StatusData:   statusData,
+		OutdatedData: outdatedData,
+		Timestamp:    time.Now().Unix(),
+		StatusOnly:   true,
+	}
+	writeCache(cached)
+	return outdatedData
 }
 
-var formatOutput = func(tools map[string]ToolStatus, outdatedTools map[string]OutdatedStatus, config ProtoConfig) string {
-	var formatted strings.Builder
-	tmplStr := config.Template
-	if tmplStr == "" {
-		tmplStr = defaultTemplate
+func protoJSONArgs(command string, config ProtoConfig) []string {
+	args := []string{command, "--json"}
+	if flags := getConfigModeFlags(config.ConfigMode); len(flags) > 0 {
+		args = append(args, flags...)
 	}
+	return args
+}
 
-	funcMap := template.FuncMap{
-		"eq":      func(a, b any) bool { return a == b },
-		"ne":      func(a, b any) bool { return a != b },
-		"fgColor": templateFgColor,
-		"bgColor": templateBgColor,
-		"reset":   func() string { return ResetColor },
+// runProto runs a single proto invocation bounded by the configured
+// per-command timeout.
+func runProto(ctx context.Context, config ProtoConfig, args []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, getCommandTimeout(config))
+	defer cancel()
+
+	proto := ProtoExecConfig{
+		Path: resolveProtoPath(config),
+		Args: append(append([]string{}, config.Proto.Args...), args...),
+		Env:  make(map[string]string, len(config.Proto.Env)+1),
+	}
+	for key, value := range config.Proto.Env {
+		proto.Env[key] = value
+	}
+	if isOffline(config) {
+		proto.Env["PROTO_OFFLINE"] = "1"
 	}
 
-	tmpl, err := template.New("output").Funcs(funcMap).Parse(tmplStr)
+	start := time.Now()
+	output, err := runProtoCommand(ctx, proto)
+	if err != nil {
+		return output, newProtoError(args, err, "", time.Since(start))
+	}
+	return output, nil
+}
+
+// runProtoCommand runs proto and returns its stdout. Failures are returned
+// as a *ProtoError carrying the exit code and the tail of stderr.
+var runProtoCommand = func(ctx context.Context, proto ProtoExecConfig) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, proto.Path, proto.Args...)
+	if len(proto.Env) > 0 {
+		cmd.Env = mergeEnv(os.Environ(), proto.Env)
+	}
+	stderr := &tailBuffer{limit: stderrTailBytes}
+	cmd.Stderr = stderr
+	configureProcessGroup(cmd)
+
+	start := time.Now()
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+		return output, newProtoError(proto.Args, err, stderr.String(), time.Since(start))
+	}
+	return output, nil
+}
+
+// mergeEnv applies overrides to a KEY=VALUE environment list.
+func mergeEnv(environ []string, overrides map[string]string) []string {
+	merged := make([]string, 0, len(environ)+len(overrides))
+	for _, entry := range environ {
+		key, _, _ := strings.Cut(entry, "=")
+		if _, overridden := overrides[key]; !overridden {
+			merged = append(merged, entry)
+		}
+	}
+	for key, value := range overrides {
+		merged = append(merged, key+"="+value)
+	}
+	return merged
+}
+
+var formatOutput = func(tools map[string]ToolStatus, outdatedTools map[string]OutdatedStatus, config ProtoConfig, info FetchInfo) string {
+	tmpl, err := parseConfigTemplate(config, toolTemplate(config))
 	if err != nil {
 		return ""
 	}
@@ -520,16 +1126,15 @@ var formatOutput = func(tools map[string]ToolStatus, outdatedTools map[string]Ou
 	}
 	sort.Strings(toolNames)
 
-	for _, tool := range toolNames {
-		status := tools[tool]
+	wd, _ := os.Getwd()
+	homeDir, _ := os.UserHomeDir()
 
-		var display string
-		if iconConfig, ok := config.Tools[tool]; ok {
-			icon := decodeUnicodeHex(iconConfig.Icon)
-			iconColor := formatColor(iconConfig.Color, true)
-			display = fmt.Sprintf("%s%s%s", iconColor, icon, "\x1b[0m")
-		} else {
-			display = tool
+	var renderErrs []RenderError
+	built := make(map[string]TemplateData, len(toolNames))
+	shown := make([]string, 0, len(toolNames))
+	for _, tool := range toolNames {
+		if toolConfig, _ := lookupToolConfig(config, tool); toolConfig.Hidden {
+			continue
 		}
 
 		var outdated *OutdatedStatus
@@ -537,59 +1142,404 @@ var formatOutput = func(tools map[string]ToolStatus, outdatedTools map[string]Ou
 			outdated = &out
 		}
 
-		var configVersion string
-		var newestVersion string
-		var latestVersion string
+		if !config.Display.showsSource(configSourceKind(config, wd, homeDir, toolConfigSource(tools[tool], outdated))) {
+			continue
+		}
 
-		configVersion = status.ConfigVersion
-		newestVersion = status.ResolvedVersion
-		latestVersion = status.ResolvedVersion
+		data, err := buildToolData(tool, tools[tool], outdated, config, info, wd, homeDir)
+		if err != nil {
+			renderErrs = append(renderErrs, *err)
+			continue
+		}
+		if containsString(config.Display.HideWhen, data.State) {
+			continue
+		}
+		built[tool] = data
+		shown = append(shown, tool)
+	}
 
-		if outdated != nil {
-			if outdated.NewestVersion != "" {
-				newestVersion = outdated.NewestVersion
-			}
-			if outdated.LatestVersion != "" {
-				latestVersion = outdated.LatestVersion
+	// Tools are sorted once their state and source are known; a configured
+	// order wins over the sort mode.
+	sortTools(shown, built, config, wd, homeDir)
+	sortToolsByOrder(shown, config)
+	toolData := make([]TemplateData, 0, len(shown))
+	for _, tool := range shown {
+		toolData = append(toolData, built[tool])
+	}
+
+	// Each tool is rendered with its own template if it has one. Positions
+	// count rendered tools only, so when a tool fails the rest are numbered
+	// again and rendered once more.
+	toolTemplates := map[string]*template.Template{"": tmpl}
+	failed := make(map[string]bool)
+	renderTools := func(toolData []TemplateData) []TemplateData {
+		for {
+			for i := range toolData {
+				toolData[i].Index = i
+				toolData[i].IsFirst = i == 0
+				toolData[i].IsLast = i == len(toolData)-1
 			}
-		}
 
-		data := TemplateData{
-			Tool:            tool,
-			ToolIcon:        display,
-			IsInstalled:     status.IsInstalled,
-			ResolvedVersion: status.ResolvedVersion,
-			IsLatest:        outdated != nil && outdated.IsLatest,
-			IsOutdated:      outdated != nil && outdated.IsOutdated,
-			ConfigVersion:   configVersion,
-			NewestVersion: func() string {
-				if newestVersion != "" {
-					return newestVersion
+			rendered := make([]TemplateData, 0, len(toolData))
+			for _, data := range toolData {
+				toolConfig, _ := lookupToolConfig(config, data.Tool)
+				toolTmpl, ok := toolTemplates[toolConfig.Template]
+				if !ok {
+					var err error
+					toolTmpl, err = parseConfigTemplate(config, toolConfig.Template)
+					if err != nil {
+						if !failed[data.Tool] {
+							failed[data.Tool] = true
+							renderErrs = append(renderErrs, RenderError{Tool: data.Tool, Message: err.Error(), Timestamp: time.Now().Unix()})
+						}
+						continue
+					}
+					toolTemplates[toolConfig.Template] = toolTmpl
 				}
-				return status.ResolvedVersion
-			}(),
-			LatestVersion: func() string {
-				if latestVersion != "" {
-					return latestVersion
+
+				segment, err := renderTool(toolTmpl, data)
+				if err != nil {
+					if !failed[data.Tool] {
+						failed[data.Tool] = true
+						renderErrs = append(renderErrs, *err)
+					}
+					continue
 				}
-				return status.ResolvedVersion
-			}(),
+				data.Output = segment
+				rendered = append(rendered, data)
+			}
+
+			if len(rendered) == len(toolData) {
+				return rendered
+			}
+			toolData = rendered
 		}
+	}
+	rendered := renderTools(toolData)
 
-		var buf bytes.Buffer
-		if err := tmpl.Execute(&buf, data); err != nil {
-			continue
+	// When tools are dropped to fit the width, the ones kept are rendered
+	// again, so that .IsLast and .Output describe what is shown.
+	renderKept := func(kept []TemplateData) []TemplateData {
+		if len(kept) == len(rendered) {
+			return rendered
+		}
+		return renderTools(append([]TemplateData(nil), kept...))
+	}
+
+	if config.LayoutTemplate != "" {
+		layout, err := parseLayoutTemplate(config, tmpl)
+		if err != nil {
+			renderErrs = append(renderErrs, RenderError{Message: err.Error(), Timestamp: time.Now().Unix()})
+			recordRenderErrors(config, renderErrs)
+			return ""
 		}
 
-		formatted.WriteString(buf.String())
+		var layoutErr *RenderError
+		output := fitWidth(rendered, config.Display, func(kept []TemplateData) string {
+			output, err := renderLayout(layout, renderKept(kept))
+			if err != nil {
+				layoutErr = err
+			}
+			return output
+		})
+		if layoutErr != nil {
+			renderErrs = append(renderErrs, *layoutErr)
+		}
+		recordRenderErrors(config, renderErrs)
+		return output
+	}
+
+	output := fitWidth(rendered, config.Display, func(kept []TemplateData) string {
+		var formatted strings.Builder
+		for _, data := range renderKept(kept) {
+			formatted.WriteString(data.Output)
+		}
+		return strings.TrimRight(formatted.String(), " ")
+	})
+	recordRenderErrors(config, renderErrs)
+	return output
+}
+
+// showsSource reports whether tools pinned in a source of this kind are
+// displayed. Tools without a reported source only pass when no sources are
+// required.
+func (d DisplayConfig) showsSource(kind string) bool {
+	if d.HideGlobal && kind == SourceGlobal {
+		return false
 	}
+	return len(d.ShowOnlySources) == 0 || containsString(d.ShowOnlySources, kind)
+}
+
+// sortToolsByOrder moves tools with a configured order to the front, in
+// that order, keeping the rest in their current order.
+func sortToolsByOrder(toolNames []string, config ProtoConfig) {
+	sort.SliceStable(toolNames, func(i, j int) bool {
+		a, _ := lookupToolConfig(config, toolNames[i])
+		b, _ := lookupToolConfig(config, toolNames[j])
+		if a.Order == 0 || b.Order == 0 {
+			return b.Order == 0 && a.Order != 0
+		}
+		return a.Order < b.Order
+	})
+}
 
-	return strings.TrimRight(formatted.String(), " ")
+// parseLayoutTemplate parses layout_template with the per-tool template
+// available to it as the "tool" template.
+func parseLayoutTemplate(config ProtoConfig, tmpl *template.Template) (*template.Template, error) {
+	layout, err := parseConfigTemplate(config, config.LayoutTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := layout.AddParseTree("tool", tmpl.Tree); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// renderLayout renders the parsed layout_template over all tools.
+func renderLayout(layout *template.Template, toolData []TemplateData) (output string, renderErr *RenderError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := recoverRenderError("", r)
+			output, renderErr = "", &err
+		}
+	}()
+
+	data := LayoutData{Tools: toolData, Count: len(toolData)}
+	for _, tool := range toolData {
+		if tool.IsInstalled {
+			data.InstalledCount++
+		} else {
+			data.MissingCount++
+		}
+		if tool.IsOutdated {
+			data.OutdatedCount++
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := layout.Execute(&buf, data); err != nil {
+		return "", &RenderError{Message: err.Error(), Timestamp: time.Now().Unix()}
+	}
+	return buf.String(), nil
+}
+
+// renderTool executes the per-tool template. A template error or a panic
+// drops only this tool.
+func renderTool(tmpl *template.Template, data TemplateData) (segment string, renderErr *RenderError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := recoverRenderError(data.Tool, r)
+			segment, renderErr = "", &err
+		}
+	}()
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", &RenderError{Tool: data.Tool, Message: err.Error(), Timestamp: time.Now().Unix()}
+	}
+
+	return buf.String(), nil
+}
+
+// buildToolData prepares the template data of a single tool. A panic, e.g.
+// from an invalid icon or color, drops only this tool.
+func buildToolData(tool string, status ToolStatus, outdated *OutdatedStatus, config ProtoConfig, info FetchInfo, wd, homeDir string) (data TemplateData, renderErr *RenderError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := recoverRenderError(tool, r)
+			data, renderErr = TemplateData{}, &err
+		}
+	}()
+
+	backend, toolName := parseToolID(tool)
+
+	toolConfig, _ := lookupToolConfig(config, tool)
+	label := toolName
+	if toolConfig.Label != "" {
+		label = toolConfig.Label
+	}
+
+	var display string
+	if toolConfig.Icon != "" {
+		icon := decodeUnicodeHex(toolConfig.Icon)
+		iconColor := formatColor(toolConfig.Color, true)
+		display = fmt.Sprintf("%s%s%s", iconColor, icon, "\x1b[0m")
+	} else {
+		display = label
+	}
+
+	// Without outdated data the newest and latest versions are unknown;
+	// they must not default to the resolved version, which would make
+	// the tool look up to date.
+	outdatedUnknown := info.OutdatedUnknown || info.OutdatedPending || outdated == nil
+
+	var outdatedError string
+	if info.OutdatedError != nil {
+		outdatedError = info.OutdatedError.Error()
+	}
+
+	var configVersion string
+	var newestVersion string
+	var latestVersion string
+
+	configVersion = status.ConfigVersion
+
+	if !outdatedUnknown {
+		newestVersion = status.ResolvedVersion
+		latestVersion = status.ResolvedVersion
+		if outdated.NewestVersion != "" {
+			newestVersion = outdated.NewestVersion
+		}
+		if outdated.LatestVersion != "" {
+			latestVersion = outdated.LatestVersion
+		}
+	}
+
+	data = TemplateData{
+		Tool:             tool,
+		Backend:          backend,
+		ToolName:         toolName,
+		Label:            label,
+		ToolIcon:         display,
+		IsInstalled:      status.IsInstalled,
+		ResolvedVersion:  status.ResolvedVersion,
+		IsLatest:         outdated != nil && outdated.IsLatest,
+		IsOutdated:       outdated != nil && outdated.IsOutdated,
+		OutdatedPending:  info.OutdatedPending,
+		OutdatedUnknown:  outdatedUnknown,
+		OutdatedError:    outdatedError,
+		ConfigVersion:    configVersion,
+		NewestVersion:    newestVersion,
+		LatestVersion:    latestVersion,
+		ProtoEnv:         getProtoEnv(config),
+		ProductDir:       status.ProductDir,
+		UpdateType:       updateType(status.ResolvedVersion, newestVersion),
+		LatestUpdateType: updateType(status.ResolvedVersion, latestVersion),
+		MajorsBehind:     majorsBehind(status.ResolvedVersion, latestVersion),
+	}
+
+	if source := toolConfigSource(status, outdated); source != "" {
+		data.ConfigSource = source
+		data.SourceRelative = source
+		if rel, err := filepath.Rel(wd, source); err == nil {
+			data.SourceRelative = rel
+		}
+		switch configSourceKind(config, wd, homeDir, source) {
+		case SourceLocal:
+			data.IsLocal = true
+		case SourceInherited:
+			data.IsInherited = true
+		case SourceGlobal:
+			data.IsGlobal = true
+		}
+	}
+
+	data.State = toolState(data)
+	return data, nil
+}
+
+// toolConfigSource returns the .prototools file a tool is pinned in, as
+// reported by proto status or, failing that, proto outdated.
+func toolConfigSource(status ToolStatus, outdated *OutdatedStatus) string {
+	if status.ConfigSource == "" && outdated != nil {
+		return outdated.ConfigSource
+	}
+	return status.ConfigSource
+}
+
+// parseToolID splits a tool ID such as "asdf:terraform" into its plugin
+// backend and tool name. Tools without a backend return an empty backend.
+func parseToolID(tool string) (backend, name string) {
+	if backend, name, ok := strings.Cut(tool, ":"); ok && backend != "" && name != "" {
+		return backend, name
+	}
+	return "", tool
+}
+
+// lookupToolConfig finds the display options for a tool ID: the exact ID
+// first, then the bare tool name, then the default of its backend.
+func lookupToolConfig(config ProtoConfig, tool string) (IconConfig, bool) {
+	if iconConfig, ok := config.Tools[tool]; ok {
+		return iconConfig, true
+	}
+
+	backend, name := parseToolID(tool)
+	if backend == "" {
+		return IconConfig{}, false
+	}
+	if iconConfig, ok := config.Tools[name]; ok {
+		return iconConfig, true
+	}
+	iconConfig, ok := config.Backends[backend]
+	return iconConfig, ok
+}
+
+// renderError renders config.ErrorTemplate for a failure at stage. Without
+// an error template the segment stays empty.
+func renderError(config ProtoConfig, stage string, err error) string {
+	if config.ErrorTemplate == "" {
+		return ""
+	}
+
+	tmpl, parseErr := parseConfigTemplate(config, config.ErrorTemplate)
+	if parseErr != nil {
+		return ""
+	}
+
+	data := ErrorData{Stage: stage, Message: err.Error()}
+	var protoErr *ProtoError
+	if errors.As(err, &protoErr) {
+		data.Message = protoErr.Message
+		if protoErr.Stderr != "" {
+			data.Message = lastLine(protoErr.Stderr)
+		}
+		data.Command = protoErr.Command
+		data.ExitCode = protoErr.ExitCode
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return ""
+	}
+	return strings.TrimRight(buf.String(), " ")
+}
+
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"eq":      func(a, b any) bool { return a == b },
+		"ne":      func(a, b any) bool { return a != b },
+		"fgColor": templateFgColor,
+		"bgColor": templateBgColor,
+		"reset":   func() string { return ResetColor },
+
+		"semverCompare": semverCompare,
+		"major":         semverMajor,
+		"minor":         semverMinor,
+		"patch":         semverPatch,
+		"satisfies":     templateSatisfies,
+		"isPrerelease":  isPrerelease,
+
+		"trimPrefix": templateTrimPrefix,
+		"truncate":   truncate,
+		"padRight":   padRight,
+		"upper":      strings.ToUpper,
+		"default":    defaultValue,
+		"join":       join,
+		"env":        os.Getenv,
+	}
+}
+
+func parseTemplate(tmplStr string) (*template.Template, error) {
+	return template.New("output").Funcs(templateFuncs()).Parse(tmplStr)
 }
 
 func formatColor(color string, foreground bool) string {
 	if strings.HasPrefix(color, "#") {
 		ansiColor := hexToANSI256(color)
+		if ansiColor == "" {
+			return ""
+		}
 		code := 38
 		if !foreground {
 			code = 48
@@ -629,12 +1579,22 @@ func resolveColorName(color string) string {
 	return color
 }
 
+// hexToANSI256 converts #RRGGBB or #RGB to the nearest 256-color code. It
+// returns "" for anything else.
 func hexToANSI256(hex string) string {
 	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return ""
+	}
 
-	r, _ := strconv.ParseInt(hex[0:2], 16, 64)
-	g, _ := strconv.ParseInt(hex[2:4], 16, 64)
-	b, _ := strconv.ParseInt(hex[4:6], 16, 64)
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return ""
+	}
+	r, g, b := int64(rgb>>16), int64(rgb>>8&0xff), int64(rgb&0xff)
 
 	if r == g && g == b {
 		if r < 8 {
@@ -664,8 +1624,11 @@ func getDefaultConfigContent() string {
 
  	// Template for formatting tool output (Go template syntax)
  	// Variables:
- 	//   .Tool - Tool name
- 	//   .ToolIcon - Icon with color formatting (falls back to tool name)
+ 	//   .Tool - Tool ID (e.g., "node", "asdf:terraform")
+ 	//   .Backend - Plugin backend of the tool ID (e.g., "asdf"), empty for regular tools
+ 	//   .ToolName - Tool ID without the backend (e.g., "terraform")
+ 	//   .Label - Configured label (falls back to .ToolName)
+ 	//   .ToolIcon - Icon with color formatting (falls back to .Label)
  	//   .IsInstalled - Boolean: tool is installed
  	//   .IsLatest - Boolean: current version is newest matching constraint
  	//   .IsOutdated - Boolean: newer version available
@@ -673,17 +1636,123 @@ func getDefaultConfigContent() string {
  	//   .ConfigVersion - Version constraint (e.g., "~22", "^1.20")
  	//   .NewestVersion - Newest version matching constraint
  	//   .LatestVersion - Absolute latest version
+ 	//   .OutdatedPending - Boolean: outdated data is still being fetched (see prompt_budget_ms)
+ 	//   .OutdatedUnknown - Boolean: no outdated data (offline, pending or not reported by proto);
+ 	//                      .NewestVersion and .LatestVersion are empty
+ 	//   .OutdatedError - Message of the failed outdated query, if any
+ 	//   .UpdateType - Update to .NewestVersion: "major", "minor", "patch", "prerelease" or "none"
+ 	//   .LatestUpdateType - Update to .LatestVersion, same values as .UpdateType
+ 	//   .MajorsBehind - Number of major versions between .ResolvedVersion and .LatestVersion
+ 	//   .ProtoEnv - Active proto environment from PROTO_ENV (e.g., "production"), empty if unset
+ 	//   .ConfigSource - .prototools file the tool is pinned in, empty if proto did not report it
+ 	//   .SourceRelative - .ConfigSource relative to the working directory (e.g., "../.prototools")
+ 	//   .IsLocal, .IsInherited, .IsGlobal - Boolean: pinned in the working directory, a parent
+ 	//                      directory or $PROTO_HOME
+ 	//   .ProductDir - Install directory of the resolved version
+ 	//   .Index - Position in the output, starting at 0
+ 	//   .IsFirst, .IsLast - Boolean: first or last tool in the output
+ 	//   .State - "latest", "newest", "outdated", "missing" or "unknown"
  	// Functions:
  	//   eq(a, b) - Equal
  	//   ne(a, b) - Not equal
  	//   fgColor(color) - Set foreground color (hex code, name, or ANSI code)
  	//   bgColor(color) - Set background color (hex code, name, or ANSI code)
  	//   reset() - Reset all formatting
+ 	//   semverCompare(a, b) - -1, 0 or 1; non-versions compare as strings
+ 	//   major(v), minor(v), patch(v) - Version component, 0 if v is not a version
+ 	//   satisfies(v, req) - v matches a requirement, e.g. satisfies .ResolvedVersion "^22"
+ 	//   isPrerelease(v) - v has a prerelease tag, e.g. "1.2.0-rc.1"
+ 	//   trimPrefix(prefix, s) - Remove a leading prefix, e.g. .ResolvedVersion | trimPrefix "v"
+ 	//   truncate(n, s) - Keep the first n characters
+ 	//   padRight(n, s) - Pad with spaces to n characters
+ 	//   upper(s) - Upper case
+ 	//   default(fallback, value) - fallback if value is empty, e.g. .ConfigVersion | default "?"
+ 	//   join(sep, list) - Join a list
+ 	//   env(name) - Environment variable
   	"template": ` + fmt.Sprintf("%q", defaultTemplate) + `,
 
+	// Read "template" from a file instead, relative to this config file
+	// (e.g., "prompt.tmpl"). When set, it replaces "template"
+	"template_file": "",
+
+	// Named partials, used from any template as {{template "name" .}}
+	// Files named <name>.tmpl in the "config.templates" directory next to this config
+	// file (named after it) are partials too; an entry here wins over a file of the same name
+	// "output" and "tool" are reserved names
+	// Example: {"version": "{{.ResolvedVersion | trimPrefix \"v\"}}"}
+	"templates": {},
+
+	// Styles by tool state, an alternative to writing a template. Only used when
+	// "template" is empty, removed or left at the default above, as a changed
+	// template always wins.
+	// States: "latest" (latest version), "newest" (newest matching the constraint),
+	// "outdated", "missing" and "unknown" (no outdated data)
+	// Per state, all optional:
+	//   "color": Color of the segment
+	//   "icon": Nerd Font hex code replacing the tool icon
+	//   "format": Text with {icon}, {tool}, {label}, {version}, {config}, {newest} and {latest}
+	// Example: {"latest": {"color": "green"}, "outdated": {"color": "yellow", "format": "{icon} {version}↑"}}
+	// The state is also available to templates as .State
+	"states": {},
+
+	// Order of the tools, unless set per tool with "order"
+	// "alphabetical": By tool ID (default)
+	// "config": As listed in "tools"
+	// "prototools": As pinned in .prototools files, closest file first
+	// "attention": Outdated, then missing, then the rest
+	// "source": Pinned in the working directory, a parent directory, then $PROTO_HOME
+	"sort": "alphabetical",
+
+	// Which tools are shown
+	// hide_when: Hide tools in these states, e.g. ["latest", "newest"] to only list tools
+	//            that need attention (states: latest, newest, outdated, missing, unknown)
+	// show_only_sources: Only show tools pinned in these .prototools locations:
+	//                    "local" (working directory), "inherited" (a parent directory),
+	//                    "global" ($PROTO_HOME/.prototools). Empty shows all
+	// hide_global: Hide tools pinned in $PROTO_HOME/.prototools
+	// max_width: Widest segment in columns; tools are dropped from the end to fit, 0 is unlimited
+	// max_width_ratio: Widest segment as a fraction of $COLUMNS (e.g., 0.3), the smaller limit wins
+	// overflow: Appended when tools are dropped, {count} is their number (default: " +{count}")
+	// icon_width: Columns of a Nerd Font icon, 2 if your terminal draws them double width
+	"display": {
+		"hide_when": [],
+		"show_only_sources": [],
+		"hide_global": false,
+		"max_width": 0,
+		"max_width_ratio": 0,
+		"overflow": " +{count}",
+		"icon_width": 1
+	},
+
+	// Optional template for the whole segment; "template" is then available as the
+	// "tool" partial. Leave empty to concatenate "template" for each tool
+	// Variables:
+	//   .Tools - List of tools, each with the variables of "template" plus .Output,
+	//            the tool rendered with its own template
+	//   .Count, .InstalledCount, .MissingCount, .OutdatedCount - Number of tools
+	// Example: "{{range .Tools}}{{template \"tool\" .}}{{if not .IsLast}} | {{end}}{{end}}{{if .OutdatedCount}} ({{.OutdatedCount}} outdated){{end}}"
+	"layout_template": "",
+
+	// Template rendered instead of an empty segment when something fails
+	// Variables:
+	//   .Stage - Where it failed: "config", "proto" (not installed), "template", "status" or "render"
+	//   .Message - Error message, the last line of proto's stderr when there is one
+	//   .Command - Failed proto command, empty for other stages
+	//   .ExitCode - Exit code of the failed proto command, -1 if it did not exit
+	// Functions are the same as for "template". Leave empty to hide the segment on failure
+	// Example: "{{fgColor \"yellow\"}}{{if eq .Stage \"proto\"}}proto not installed{{else}}\uf071{{end}}{{reset}}"
+	"error_template": "",
+
 	// Tool-specific icon and color configuration
 	// Colors support hex codes, names, or ANSI codes (e.g., "#61AFEF", "blue", "33")
 	// Icons use Nerd Font hex codes (e.g., "e76f", "e627")
+	// Plugin tools like "asdf:terraform" use the exact ID, then the bare name ("terraform"),
+	// then the "backends" entry for their backend
+	// Optional per tool:
+	//   "label": Display name for .Label and when no icon is set (default: tool name)
+	//   "hidden": true to leave the tool out
+	//   "order": Position; tools with an order come first, the rest by "sort"
+	//   "template": Template for this tool only, replacing "template"
 	"tools": {
 		"bun": {
 			"icon": "e76f",
@@ -739,12 +1808,63 @@ func getDefaultConfigContent() string {
 		}
 	},
 
+	// Default icon and color for plugin tools by backend, used when neither
+	// the tool ID nor its bare name is configured in "tools"
+	"backends": {
+		"asdf": {
+			"icon": "f1b3",
+			"color": "blue"
+		},
+		"npm": {
+			"icon": "e71e",
+			"color": "red"
+		}
+	},
+
 	// Cache configuration
 	// TTL: Time-to-live for cached data in seconds (default: ` + fmt.Sprintf("%d", defaultCacheTTL) + ` = 5 minutes)
 	// Set to 0 to disable caching, or increase for longer intervals
 	"cache": {
 		"ttl": ` + fmt.Sprintf("%d", defaultCacheTTL) + `
-	}
+	},
+
+	// Time budget for proto invocations on a cache miss
+	// command_ms: Per proto command; a hung command is killed (default: ` + fmt.Sprintf("%d", defaultCommandTimeoutMs) + `)
+	// total_ms: Overall budget; when exceeded, the last cached data (or status-only data) is rendered (default: ` + fmt.Sprintf("%d", defaultTotalTimeoutMs) + `)
+	"timeout": {
+		"command_ms": ` + fmt.Sprintf("%d", defaultCommandTimeoutMs) + `,
+		"total_ms": ` + fmt.Sprintf("%d", defaultTotalTimeoutMs) + `
+	},
+
+	// Where the upward search for .prototools files stops, besides the home directory
+	// and the filesystem root. Applies to the cache key, local status resolution and --explain
+	// markers: Stop at the first directory containing one of these (e.g., [".git", ".moon"]);
+	//          that directory's .prototools is still read
+	// max_depth: Parent directories searched above the working directory, 0 is unlimited
+	"walk": {
+		"markers": [],
+		"max_depth": 0
+	},
+
+	// Which proto install drives the prompt
+	// path: Executable, default "proto" from PATH ("~" and $VARS are expanded,
+	//       relative paths are resolved against this file's directory)
+	// args: Global arguments placed before every command
+	// env: Environment overrides, e.g. {"PROTO_LOG": "off", "PROTO_HOME": "/opt/proto"}
+	"proto": {
+		"path": "proto",
+		"args": [],
+		"env": {}
+	},
+
+	// Never run "proto outdated", which queries the network. Tools render with
+	// .OutdatedUnknown set. Also enabled by --offline or PROTO_OFFLINE=1
+	"offline": false,
+
+	// Milliseconds to wait for "proto outdated" on a cache miss before rendering
+	// status-only data; a detached "--refresh --silent" run then fills the cache
+	// for the next prompt. Set to 0 to always wait for both queries
+	"prompt_budget_ms": 0
 }`
 }
 
@@ -784,6 +1904,7 @@ func loadJSONConfig(configFile string) (ProtoConfig, error) {
 	if err := json.Unmarshal(jsonData, &config); err != nil {
 		return config, err
 	}
+	config.toolOrder = objectKeys(jsonData, "tools")
 
 	return config, nil
 }
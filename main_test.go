@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -23,6 +24,17 @@ func TestDefaultTemplate(t *testing.T) {
 	}
 }
 
+// skipInventory sends status queries to the mocked proto output instead
+// of resolving them from the .prototools files and installed tools of the
+// machine running the tests.
+func skipInventory(t *testing.T) {
+	t.Helper()
+
+	oldReadInventoryStatus := readInventoryStatus
+	t.Cleanup(func() { readInventoryStatus = oldReadInventoryStatus })
+	readInventoryStatus = func(config ProtoConfig) (map[string]ToolStatus, bool) { return nil, false }
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && containsHelper(s, substr))
 }
@@ -55,7 +67,7 @@ func TestFormatOutput(t *testing.T) {
 		"go":   {IsOutdated: false},
 	}
 
-	output := formatOutput(tools, outdated, config)
+	output := formatOutput(tools, outdated, config, FetchInfo{})
 
 	if output == "" {
 		t.Error("Expected non-empty output")
@@ -89,7 +101,7 @@ func TestFormatOutputWithNewFields(t *testing.T) {
 		"go":   {IsOutdated: false},
 	}
 
-	output := formatOutput(tools, outdated, config)
+	output := formatOutput(tools, outdated, config, FetchInfo{})
 
 	if output == "" {
 		t.Error("Expected non-empty output")
@@ -112,7 +124,7 @@ func TestFormatOutputNoOutdatedData(t *testing.T) {
 
 	outdated := map[string]OutdatedStatus{}
 
-	output := formatOutput(tools, outdated, config)
+	output := formatOutput(tools, outdated, config, FetchInfo{})
 
 	if output == "" {
 		t.Error("Expected non-empty output")
@@ -138,7 +150,7 @@ func TestFormatOutputTemplateWithEqFunction(t *testing.T) {
 		"go":   {IsOutdated: false},
 	}
 
-	output := formatOutput(tools, outdated, config)
+	output := formatOutput(tools, outdated, config, FetchInfo{})
 
 	if output == "" {
 		t.Error("Expected non-empty output")
@@ -164,7 +176,7 @@ func TestFormatOutputTemplateWithConfigVersion(t *testing.T) {
 		"go":   {IsOutdated: false},
 	}
 
-	output := formatOutput(tools, outdated, config)
+	output := formatOutput(tools, outdated, config, FetchInfo{})
 
 	if output == "" {
 		t.Error("Expected non-empty output")
@@ -195,7 +207,7 @@ func TestFormatOutputLatestVersionOnly(t *testing.T) {
 		},
 	}
 
-	output := formatOutput(tools, outdated, config)
+	output := formatOutput(tools, outdated, config, FetchInfo{})
 
 	if output == "" {
 		t.Error("Expected non-empty output")
@@ -226,7 +238,7 @@ func TestFormatOutputOutdatedVersionOnly(t *testing.T) {
 		},
 	}
 
-	output := formatOutput(tools, outdated, config)
+	output := formatOutput(tools, outdated, config, FetchInfo{})
 
 	if output == "" {
 		t.Error("Expected non-empty output")
@@ -327,15 +339,16 @@ func TestFormatOutputIntegration(t *testing.T) {
 				forceRefresh = oldForceRefresh
 			}()
 
+			skipInventory(t)
 			cacheFile := tt.setupCache()
 			getCacheFile = func() string { return cacheFile }
-			getDirectoryContext = func(configMode string) (string, error) { return "test-hash", nil }
+			getDirectoryContext = func(config ProtoConfig) (string, error) { return "test-hash", nil }
 			forceRefresh = tt.forceRefresh
-			runProtoCommand = func(args []string) ([]byte, error) {
+			runProtoCommand = func(ctx context.Context, proto ProtoExecConfig) ([]byte, error) {
 				return []byte(tt.mockOutput), nil
 			}
 
-			tools, err := getToolStatus(tt.config)
+			tools, err := getToolStatus(context.Background(), tt.config)
 			if err != nil {
 				t.Fatalf("getToolStatus() error = %v", err)
 			}
@@ -369,7 +382,7 @@ func TestFormatOutputIntegration(t *testing.T) {
 				Cache: CacheConfig{TTL: 300},
 			}
 
-			output := formatOutput(tools, map[string]OutdatedStatus{}, updatedConfig)
+			output := formatOutput(tools, map[string]OutdatedStatus{}, updatedConfig, FetchInfo{})
 
 			if output == "" {
 				t.Error("Expected non-empty output from formatOutput")
@@ -398,7 +411,7 @@ func TestGetProtoStatus_CompleteWorkflow(t *testing.T) {
 		formatOutput = oldFormatOutput
 	}()
 
-	protoInstalled = func() bool { return true }
+	protoInstalled = func(config ProtoConfig) bool { return true }
 	loadConfig = func() (ProtoConfig, error) {
 		return ProtoConfig{
 			Tools: map[string]IconConfig{
@@ -409,19 +422,19 @@ func TestGetProtoStatus_CompleteWorkflow(t *testing.T) {
 			Cache:    CacheConfig{TTL: 300},
 		}, nil
 	}
-	getToolStatus = func(config ProtoConfig) (map[string]ToolStatus, error) {
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
 		return map[string]ToolStatus{
 			"node": {ResolvedVersion: "24.0.0", IsInstalled: true},
 			"go":   {ResolvedVersion: "1.26.0", IsInstalled: true},
 		}, nil
 	}
-	getOutdatedStatus = func(config ProtoConfig) map[string]OutdatedStatus {
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
 		return map[string]OutdatedStatus{
 			"node": {IsOutdated: false},
 			"go":   {IsOutdated: false},
-		}
+		}, nil
 	}
-	formatOutput = func(tools map[string]ToolStatus, outdatedTools map[string]OutdatedStatus, config ProtoConfig) string {
+	formatOutput = func(tools map[string]ToolStatus, outdatedTools map[string]OutdatedStatus, config ProtoConfig, info FetchInfo) string {
 		var result string
 		for _, toolName := range []string{"node", "go"} {
 			if tool, ok := tools[toolName]; ok {
@@ -434,7 +447,7 @@ func TestGetProtoStatus_CompleteWorkflow(t *testing.T) {
 		return result
 	}
 
-	output := getProtoStatus()
+	output := getProtoStatus(context.Background())
 
 	if output == "" {
 		t.Error("Expected non-empty output")
@@ -0,0 +1,73 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigureProcessGroupKillsChildren(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The backgrounded sleep inherits stdout; without a group kill Output()
+	// would block until it exits.
+	cmd := exec.CommandContext(ctx, "sh", "-c", "sleep 5 & sleep 5")
+	configureProcessGroup(cmd)
+
+	start := time.Now()
+	if _, err := cmd.Output(); err == nil {
+		t.Fatal("Expected command to be killed")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Command took %v, want it killed at the deadline", elapsed)
+	}
+}
+
+func TestRunProtoCommandWrapperScript(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "proto-wrapper")
+	os.WriteFile(script, []byte("#!/bin/sh\necho \"$PROTO_LOG $*\"\n"), 0755)
+
+	t.Setenv("PROTO_LOG", "debug")
+	config := ProtoConfig{
+		Proto: ProtoExecConfig{
+			Path: script,
+			Args: []string{"--color", "never"},
+			Env:  map[string]string{"PROTO_LOG": "off"},
+		},
+	}
+
+	if !protoInstalled(config) {
+		t.Fatal("protoInstalled() should find the configured executable")
+	}
+
+	output, err := runProto(context.Background(), config, []string{"status", "--json"})
+	if err != nil {
+		t.Fatalf("runProto() error = %v", err)
+	}
+	if got := strings.TrimSpace(string(output)); got != "off --color never status --json" {
+		t.Errorf("runProto() = %q", got)
+	}
+}
+
+func TestRunProtoCommandCapturesStderr(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "proto")
+	os.WriteFile(script, []byte("#!/bin/sh\necho 'error: plugin failed' >&2\nexit 3\n"), 0755)
+
+	_, err := runProtoCommand(context.Background(), ProtoExecConfig{Path: script, Args: []string{"status"}})
+
+	var protoErr *ProtoError
+	if !errors.As(err, &protoErr) {
+		t.Fatalf("runProtoCommand() error = %v, want ProtoError", err)
+	}
+	if protoErr.ExitCode != 3 || protoErr.Stderr != "error: plugin failed" || protoErr.Command != "proto status" {
+		t.Errorf("ProtoError = %+v", protoErr)
+	}
+}
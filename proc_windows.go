@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// detachedProcess is DETACHED_PROCESS, which syscall does not define.
+const detachedProcess = 0x00000008
+
+// configureProcessGroup relies on the default cancellation, which kills the
+// proto process; WaitDelay keeps orphaned children from holding the pipes.
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = 500 * time.Millisecond
+}
+
+// detachProcess starts cmd without a console and in its own process group,
+// so it outlives this process and ignores the shell's Ctrl-C.
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Prototools is a parsed .prototools file.
+type Prototools struct {
+	Path      string
+	Tools     map[string]string // Tool pins, e.g. node = "~22"
+	ToolOrder []string          // Tool pins in the order they appear in the file
+	Plugins   map[string]string // Plugin locators from [plugins] and its sub-tables
+	Settings  map[string]any
+	Env       map[string]string
+}
+
+func parsePrototools(path string) (Prototools, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Prototools{}, err
+	}
+
+	root, order, err := parseTOML(data)
+	if err != nil {
+		return Prototools{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	p := Prototools{
+		Path:     path,
+		Tools:    make(map[string]string),
+		Plugins:  make(map[string]string),
+		Settings: make(map[string]any),
+		Env:      make(map[string]string),
+	}
+
+	for _, key := range order {
+		if version, ok := root[key].(string); ok {
+			p.Tools[key] = version
+			p.ToolOrder = append(p.ToolOrder, key)
+		}
+	}
+
+	if plugins, ok := root["plugins"].(map[string]any); ok {
+		// Newer proto releases group plugins as [plugins.tools] and
+		// [plugins.backends]; older ones list them directly.
+		for key, value := range plugins {
+			switch v := value.(type) {
+			case string:
+				p.Plugins[key] = v
+			case map[string]any:
+				for id, locator := range v {
+					if s, ok := locator.(string); ok {
+						p.Plugins[id] = s
+					}
+				}
+			}
+		}
+	}
+
+	if settings, ok := root["settings"].(map[string]any); ok {
+		p.Settings = settings
+	}
+
+	if env, ok := root["env"].(map[string]any); ok {
+		for key, value := range env {
+			switch v := value.(type) {
+			case string:
+				p.Env[key] = v
+			case bool, int64, float64:
+				p.Env[key] = fmt.Sprint(v)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// getProtoHome returns PROTO_HOME as proto sees it, defaulting to ~/.proto.
+func getProtoHome(config ProtoConfig, homeDir string) string {
+	if protoHome := lookupProtoEnv(config, "PROTO_HOME"); protoHome != "" {
+		return protoHome
+	}
+	return filepath.Join(homeDir, ".proto")
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// prototoolsFilesForMode lists the .prototools files proto reads for the
+// given config mode, highest precedence first.
+func prototoolsFilesForMode(wd, homeDir string, config ProtoConfig) []string {
+	var files []string
+	for _, path := range prototoolsCandidatesForMode(wd, homeDir, config) {
+		if isFile(path) {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// prototoolsCandidatesForMode lists every .prototools path proto may read
+// for the given config mode, whether it exists or not. With PROTO_ENV set,
+// each location's .prototools.<env> comes before its .prototools, as it
+// takes precedence.
+func prototoolsCandidatesForMode(wd, homeDir string, config ProtoConfig) []string {
+	names := prototoolsNames(getProtoEnv(config))
+	globalPaths := dirCandidates(getProtoHome(config, homeDir), names)
+
+	switch getConfigMode(config.ConfigMode) {
+	case "local":
+		return dirCandidates(wd, names)
+	case "global":
+		return globalPaths
+	case "upwards-global", "all":
+		paths := walkCandidates(wd, homeDir, config.Walk, names)
+		for _, path := range globalPaths {
+			if !containsString(paths, path) {
+				paths = append(paths, path)
+			}
+		}
+		return paths
+	default:
+		return walkCandidates(wd, homeDir, config.Walk, names)
+	}
+}
+
+// getProtoEnv returns the active proto environment from PROTO_ENV.
+func getProtoEnv(config ProtoConfig) string {
+	return lookupProtoEnv(config, "PROTO_ENV")
+}
+
+// prototoolsNames returns the config file names proto reads in a single
+// directory, highest precedence first.
+func prototoolsNames(protoEnv string) []string {
+	if protoEnv == "" {
+		return []string{".prototools"}
+	}
+	return []string{".prototools." + protoEnv, ".prototools"}
+}
+
+func dirCandidates(dir string, names []string) []string {
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	return paths
+}
+
+func walkCandidates(wd, homeDir string, walk WalkConfig, names []string) []string {
+	dirs, _ := walkDirs(wd, homeDir, walk)
+	paths := make([]string, 0, len(dirs)*len(names))
+	for _, dir := range dirs {
+		paths = append(paths, dirCandidates(dir, names)...)
+	}
+	return paths
+}
+
+// walkMarkerCandidates lists every walk marker path checked while walking up
+// for the config mode: each marker in each walked directory, including the
+// one that stopped the walk.
+func walkMarkerCandidates(wd, homeDir string, config ProtoConfig) []string {
+	if len(config.Walk.Markers) == 0 {
+		return nil
+	}
+	switch getConfigMode(config.ConfigMode) {
+	case "local", "global":
+		return nil
+	}
+
+	dirs, _ := walkDirs(wd, homeDir, config.Walk)
+	paths := make([]string, 0, len(dirs)*len(config.Walk.Markers))
+	for _, dir := range dirs {
+		paths = append(paths, dirCandidates(dir, config.Walk.Markers)...)
+	}
+	return paths
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfiguredVersions resolves tool pins from .prototools files the way
+// proto does for the configured config mode, without running proto. The
+// returned statuses only carry ConfigVersion and ConfigSource.
+func loadConfiguredVersions(wd, homeDir string, config ProtoConfig) (map[string]ToolStatus, []Prototools, error) {
+	tools := make(map[string]ToolStatus)
+	var files []Prototools
+
+	for _, path := range prototoolsFilesForMode(wd, homeDir, config) {
+		p, err := parsePrototools(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, p)
+
+		for _, tool := range p.ToolOrder {
+			if _, pinned := tools[tool]; pinned {
+				continue
+			}
+			tools[tool] = ToolStatus{
+				ConfigSource:  path,
+				ConfigVersion: p.Tools[tool],
+			}
+		}
+	}
+
+	return tools, files, nil
+}
+
+// Kinds of config source, by where the .prototools file that pins a tool is.
+const (
+	SourceLocal     = "local"     // The working directory
+	SourceInherited = "inherited" // A parent directory
+	SourceGlobal    = "global"    // $PROTO_HOME
+)
+
+// configSourceKind classifies the .prototools file a tool is pinned in. It
+// returns "" when proto did not report a source.
+func configSourceKind(config ProtoConfig, wd, homeDir, source string) string {
+	if source == "" {
+		return ""
+	}
+	dir := filepath.Dir(source)
+	switch {
+	case sameDir(dir, getProtoHome(config, homeDir)):
+		return SourceGlobal
+	case sameDir(dir, wd):
+		return SourceLocal
+	default:
+		return SourceInherited
+	}
+}
+
+// sameDir compares directories, resolving symlinks only when the paths
+// differ, as proto may report canonical paths.
+func sameDir(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	resolvedA, errA := filepath.EvalSymlinks(a)
+	resolvedB, errB := filepath.EvalSymlinks(b)
+	return errA == nil && errB == nil && resolvedA == resolvedB
+}
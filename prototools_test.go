@@ -0,0 +1,432 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	input := `# Project tools
+node = "~22"   # trailing comment
+"asdf:terraform" = '1.5'
+bun.version = "1"
+
+[settings]
+auto-install = true
+detect-strategy = "prefer-prototools"
+retries = 3
+ratio = 0.5
+builtin-plugins = [
+  "node", # inline comment
+  "go",
+]
+
+[settings.http]
+allow-invalid-certs = false
+
+[env]
+PROTO_LOG = "off"
+FILE = { file = ".env" }
+
+[[tools.node.aliases]]
+name = "work"
+`
+
+	root, order, err := parseTOML([]byte(input))
+	if err != nil {
+		t.Fatalf("parseTOML() error = %v", err)
+	}
+
+	if want := []string{"node", "asdf:terraform", "bun"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+	if root["node"] != "~22" || root["asdf:terraform"] != "1.5" {
+		t.Errorf("tool pins = %v, %v", root["node"], root["asdf:terraform"])
+	}
+	if bun, ok := root["bun"].(map[string]any); !ok || bun["version"] != "1" {
+		t.Errorf("dotted key = %v", root["bun"])
+	}
+
+	settings := root["settings"].(map[string]any)
+	if settings["auto-install"] != true || settings["retries"] != int64(3) || settings["ratio"] != 0.5 {
+		t.Errorf("settings = %v", settings)
+	}
+	if plugins := settings["builtin-plugins"].([]any); len(plugins) != 2 || plugins[1] != "go" {
+		t.Errorf("array = %v", plugins)
+	}
+	if http := settings["http"].(map[string]any); http["allow-invalid-certs"] != false {
+		t.Errorf("nested table = %v", http)
+	}
+
+	env := root["env"].(map[string]any)
+	if file := env["FILE"].(map[string]any); file["file"] != ".env" {
+		t.Errorf("inline table = %v", file)
+	}
+
+	aliases := root["tools"].(map[string]any)["node"].(map[string]any)["aliases"].([]any)
+	if len(aliases) != 1 || aliases[0].(map[string]any)["name"] != "work" {
+		t.Errorf("array of tables = %v", aliases)
+	}
+}
+
+func TestParseTOMLStrings(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"escapes", `v = "a\tb\"c\u00e9"`, "a\tb\"cé"},
+		{"literal", `v = 'C:\path'`, `C:\path`},
+		{"multiline basic", "v = \"\"\"\nline one\nline two\"\"\"", "line one\nline two"},
+		{"line ending backslash", "v = \"\"\"\none \\\n   two\"\"\"", "one two"},
+		{"multiline literal", "v = '''\nraw \\n'''", `raw \n`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, _, err := parseTOML([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("parseTOML() error = %v", err)
+			}
+			if root["v"] != tt.want {
+				t.Errorf("v = %q, want %q", root["v"], tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"missing equals", `node "22"`},
+		{"unterminated string", `node = "22`},
+		{"duplicate key", "node = \"22\"\nnode = \"23\""},
+		{"unterminated header", "[settings"},
+		{"trailing garbage", `node = "22" "23"`},
+		{"invalid value", `node = latest`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseTOML([]byte(tt.input)); err == nil {
+				t.Errorf("parseTOML(%q) expected error", tt.input)
+			}
+		})
+	}
+}
+
+func TestParsePrototools(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".prototools")
+	os.WriteFile(path, []byte(`node = "~22"
+pnpm = "9"
+
+[plugins]
+my-tool = "https://example.com/my-tool.toml"
+
+[plugins.backends]
+custom = "file://./custom.wasm"
+
+[settings]
+auto-install = true
+
+[env]
+NO_COLOR = true
+`), 0644)
+
+	p, err := parsePrototools(path)
+	if err != nil {
+		t.Fatalf("parsePrototools() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(p.ToolOrder, []string{"node", "pnpm"}) {
+		t.Errorf("ToolOrder = %v", p.ToolOrder)
+	}
+	if p.Tools["node"] != "~22" {
+		t.Errorf("Tools = %v", p.Tools)
+	}
+	if p.Plugins["my-tool"] == "" || p.Plugins["custom"] != "file://./custom.wasm" {
+		t.Errorf("Plugins = %v", p.Plugins)
+	}
+	if p.Settings["auto-install"] != true {
+		t.Errorf("Settings = %v", p.Settings)
+	}
+	if p.Env["NO_COLOR"] != "true" {
+		t.Errorf("Env = %v", p.Env)
+	}
+}
+
+func TestLoadConfiguredVersions(t *testing.T) {
+	home := t.TempDir()
+	protoHome := filepath.Join(home, ".proto")
+	project := filepath.Join(home, "work", "project")
+	os.MkdirAll(protoHome, 0755)
+	os.MkdirAll(project, 0755)
+	t.Setenv("PROTO_HOME", protoHome)
+
+	os.WriteFile(filepath.Join(protoHome, ".prototools"), []byte("node = \"20\"\ngo = \"1.23\"\n"), 0644)
+	os.WriteFile(filepath.Join(home, "work", ".prototools"), []byte("node = \"22\"\nbun = \"1\"\n"), 0644)
+	os.WriteFile(filepath.Join(project, ".prototools"), []byte("node = \"24\"\n"), 0644)
+
+	tests := []struct {
+		mode string
+		want map[string]string
+	}{
+		{"local", map[string]string{"node": "24"}},
+		{"global", map[string]string{"node": "20", "go": "1.23"}},
+		{"upwards", map[string]string{"node": "24", "bun": "1"}},
+		{"", map[string]string{"node": "24", "bun": "1"}},
+		{"upwards-global", map[string]string{"node": "24", "bun": "1", "go": "1.23"}},
+		{"all", map[string]string{"node": "24", "bun": "1", "go": "1.23"}},
+	}
+
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			tools, _, err := loadConfiguredVersions(project, home, ProtoConfig{ConfigMode: tt.mode})
+			if err != nil {
+				t.Fatalf("loadConfiguredVersions() error = %v", err)
+			}
+			got := make(map[string]string)
+			for tool, status := range tools {
+				got[tool] = status.ConfigVersion
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pins = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	tools, _, _ := loadConfiguredVersions(project, home, ProtoConfig{ConfigMode: "all"})
+	if tools["bun"].ConfigSource != filepath.Join(home, "work", ".prototools") {
+		t.Errorf("bun ConfigSource = %s", tools["bun"].ConfigSource)
+	}
+	if tools["go"].ConfigSource != filepath.Join(protoHome, ".prototools") {
+		t.Errorf("go ConfigSource = %s", tools["go"].ConfigSource)
+	}
+}
+
+func TestPrototoolsFilesWalkBoundaries(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "srv", "repo")
+	pkg := filepath.Join(repo, "packages", "app")
+	os.MkdirAll(pkg, 0755)
+	os.MkdirAll(filepath.Join(repo, ".git"), 0755)
+
+	for _, dir := range []string{root, filepath.Join(root, "srv"), repo, pkg} {
+		os.WriteFile(filepath.Join(dir, ".prototools"), []byte("node = \"22\"\n"), 0644)
+	}
+
+	tests := []struct {
+		name string
+		walk WalkConfig
+		want []string
+		stop string
+	}{
+		{
+			name: "home directory",
+			want: []string{pkg, repo, filepath.Join(root, "srv"), root},
+			stop: "home directory " + root,
+		},
+		{
+			name: "marker",
+			walk: WalkConfig{Markers: []string{".moon", ".git"}},
+			want: []string{pkg, repo},
+			stop: "marker .git in " + repo,
+		},
+		{
+			name: "max depth",
+			walk: WalkConfig{MaxDepth: 1},
+			want: []string{pkg},
+			stop: "max depth 1 at " + filepath.Join(repo, "packages"),
+		},
+		{
+			name: "missing marker",
+			walk: WalkConfig{Markers: []string{".moon"}},
+			want: []string{pkg, repo, filepath.Join(root, "srv"), root},
+			stop: "home directory " + root,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := prototoolsFilesForMode(pkg, root, ProtoConfig{Walk: tt.walk})
+			_, stop := walkDirs(pkg, root, tt.walk)
+
+			var want []string
+			for _, dir := range tt.want {
+				want = append(want, filepath.Join(dir, ".prototools"))
+			}
+			if !reflect.DeepEqual(files, want) {
+				t.Errorf("files = %v, want %v", files, want)
+			}
+			if stop != tt.stop {
+				t.Errorf("stop = %q, want %q", stop, tt.stop)
+			}
+		})
+	}
+
+	tools, _, _ := loadConfiguredVersions(pkg, root, ProtoConfig{Walk: WalkConfig{MaxDepth: 1}})
+	if tools["node"].ConfigSource != filepath.Join(pkg, ".prototools") {
+		t.Errorf("node ConfigSource = %s, want the walk bounded to the package", tools["node"].ConfigSource)
+	}
+}
+
+func TestGetDirectoryContextWalkBoundaries(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	os.MkdirAll(filepath.Join(repo, ".git"), 0755)
+	t.Setenv("HOME", root)
+	t.Chdir(repo)
+
+	bounded := ProtoConfig{Walk: WalkConfig{Markers: []string{".git"}}}
+	before, _ := getDirectoryContext(bounded)
+	beforeUnbounded, _ := getDirectoryContext(ProtoConfig{})
+
+	os.WriteFile(filepath.Join(root, ".prototools"), []byte("node = \"22\"\n"), 0644)
+
+	if after, _ := getDirectoryContext(bounded); after != before {
+		t.Error("cache key changed for a .prototools above the marker")
+	}
+	if after, _ := getDirectoryContext(ProtoConfig{}); after == beforeUnbounded {
+		t.Error("cache key did not change for a .prototools below the home directory")
+	}
+}
+
+func TestGetDirectoryContextGlobalPrototools(t *testing.T) {
+	home := t.TempDir()
+	protoHome := filepath.Join(t.TempDir(), "proto")
+	project := filepath.Join(home, "project")
+	os.MkdirAll(protoHome, 0755)
+	os.MkdirAll(project, 0755)
+	t.Setenv("HOME", home)
+	t.Setenv("PROTO_HOME", protoHome)
+	t.Chdir(project)
+
+	globalFile := filepath.Join(protoHome, ".prototools")
+	os.WriteFile(globalFile, []byte("node = \"20\"\n"), 0644)
+
+	modes := []string{"global", "upwards-global", "all", "upwards", "local"}
+	before := make(map[string]string)
+	for _, mode := range modes {
+		before[mode], _ = getDirectoryContext(ProtoConfig{ConfigMode: mode})
+	}
+
+	os.WriteFile(globalFile, []byte("node = \"22\"\n"), 0644)
+
+	for _, mode := range modes {
+		after, _ := getDirectoryContext(ProtoConfig{ConfigMode: mode})
+		readsGlobal := mode != "upwards" && mode != "local"
+		if changed := after != before[mode]; changed != readsGlobal {
+			t.Errorf("mode %s: key changed = %v, want %v", mode, changed, readsGlobal)
+		}
+	}
+}
+
+func TestGetDirectoryContextProtoExec(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+
+	base, _ := getDirectoryContext(ProtoConfig{})
+	configs := map[string]ProtoConfig{
+		"path": {Proto: ProtoExecConfig{Path: "/opt/proto/bin/proto"}},
+		"args": {Proto: ProtoExecConfig{Args: []string{"--log", "off"}}},
+		"env":  {Proto: ProtoExecConfig{Env: map[string]string{"PROTO_AUTO_INSTALL": "true"}}},
+	}
+	for name, config := range configs {
+		if key, _ := getDirectoryContext(config); key == base {
+			t.Errorf("cache key did not change for proto.%s", name)
+		}
+	}
+
+	env := map[string]string{"A": "1", "B": "2", "C": "3", "D": "4"}
+	first, _ := getDirectoryContext(ProtoConfig{Proto: ProtoExecConfig{Env: env}})
+	for i := 0; i < 10; i++ {
+		if key, _ := getDirectoryContext(ProtoConfig{Proto: ProtoExecConfig{Env: env}}); key != first {
+			t.Fatal("cache key depends on the order of proto.env")
+		}
+	}
+}
+
+func TestProtoEnvPrototools(t *testing.T) {
+	home := t.TempDir()
+	protoHome := filepath.Join(home, ".proto")
+	project := filepath.Join(home, "project")
+	os.MkdirAll(protoHome, 0755)
+	os.MkdirAll(project, 0755)
+	t.Setenv("HOME", home)
+	t.Setenv("PROTO_HOME", protoHome)
+	t.Chdir(project)
+
+	os.WriteFile(filepath.Join(project, ".prototools"), []byte("node = \"22\"\nbun = \"1\"\n"), 0644)
+	os.WriteFile(filepath.Join(project, ".prototools.production"), []byte("node = \"20\"\n"), 0644)
+	os.WriteFile(filepath.Join(protoHome, ".prototools.production"), []byte("go = \"1.23\"\n"), 0644)
+
+	t.Setenv("PROTO_ENV", "")
+	devKey, _ := getDirectoryContext(ProtoConfig{})
+
+	t.Setenv("PROTO_ENV", "production")
+	config := ProtoConfig{ConfigMode: "all"}
+
+	want := []string{
+		filepath.Join(project, ".prototools.production"),
+		filepath.Join(project, ".prototools"),
+		filepath.Join(protoHome, ".prototools.production"),
+	}
+	if files := prototoolsFilesForMode(project, home, config); !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+
+	tools, _, err := loadConfiguredVersions(project, home, config)
+	if err != nil {
+		t.Fatalf("loadConfiguredVersions() error = %v", err)
+	}
+	got := map[string]string{}
+	for tool, status := range tools {
+		got[tool] = status.ConfigVersion
+	}
+	if !reflect.DeepEqual(got, map[string]string{"node": "20", "bun": "1", "go": "1.23"}) {
+		t.Errorf("pins = %v", got)
+	}
+
+	if prodKey, _ := getDirectoryContext(ProtoConfig{}); prodKey == devKey {
+		t.Error("cache key did not change with PROTO_ENV")
+	}
+
+	output := formatOutput(map[string]ToolStatus{"node": {}}, nil, ProtoConfig{Template: "{{.ProtoEnv}}"}, FetchInfo{})
+	if output != "production" {
+		t.Errorf(".ProtoEnv = %q", output)
+	}
+}
+
+func TestConfigSourceKind(t *testing.T) {
+	home := t.TempDir()
+	protoHome := filepath.Join(home, ".proto")
+	project := filepath.Join(home, "work", "project")
+	os.MkdirAll(protoHome, 0755)
+	os.MkdirAll(project, 0755)
+	t.Setenv("PROTO_HOME", protoHome)
+
+	link := filepath.Join(t.TempDir(), "link")
+	os.Symlink(project, link)
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", ""},
+		{filepath.Join(project, ".prototools"), SourceLocal},
+		{filepath.Join(project, ".prototools.production"), SourceLocal},
+		{filepath.Join(link, ".prototools"), SourceLocal},
+		{filepath.Join(home, "work", ".prototools"), SourceInherited},
+		{filepath.Join(protoHome, ".prototools"), SourceGlobal},
+	}
+
+	for _, tt := range tests {
+		if got := configSourceKind(ProtoConfig{}, project, home, tt.source); got != tt.want {
+			t.Errorf("configSourceKind(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
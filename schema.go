@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProtoVersion is the version reported by `proto --version`.
+type ProtoVersion struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func (v ProtoVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+func (v ProtoVersion) Less(other ProtoVersion) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+	return v.Patch < other.Patch
+}
+
+// ProtoSchema lists the keys omp-prototools reads from proto's JSON output.
+type ProtoSchema struct {
+	MinVersion      ProtoVersion
+	StatusFields    []string // Keys every `proto status --json` entry must have
+	InstalledFields []string // Keys a status entry must have once the tool is installed
+	OutdatedFields  []string // Keys every `proto outdated --json` entry must have
+}
+
+// SchemaError reports JSON output that lacks keys of protoSchema.
+type SchemaError struct {
+	Command string
+	Tool    string
+	Missing []string
+	Seen    []string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Tool == "" {
+		return fmt.Sprintf("proto %s output is not a JSON object of tools", e.Command)
+	}
+	return fmt.Sprintf("proto %s output for %q is missing %s (saw %s)",
+		e.Command, e.Tool, strings.Join(e.Missing, ", "), strings.Join(e.Seen, ", "))
+}
+
+// protoSchema lists the keys read from proto 0.37, which introduced
+// `proto status`, and later. Output is only validated against it, never
+// adapted to the proto version: output that lacks a key fails with a
+// SchemaError instead of rendering empty versions.
+var protoSchema = ProtoSchema{
+	MinVersion:      ProtoVersion{0, 37, 0},
+	StatusFields:    []string{"is_installed"},
+	InstalledFields: []string{"resolved_version"},
+	OutdatedFields:  []string{"is_latest", "current_version", "newest_version", "latest_version"},
+}
+
+func parseProtoVersion(output string) (ProtoVersion, error) {
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return ProtoVersion{}, fmt.Errorf("empty proto version output")
+	}
+
+	raw := strings.TrimPrefix(fields[len(fields)-1], "v")
+	if i := strings.IndexAny(raw, "-+"); i >= 0 {
+		raw = raw[:i]
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return ProtoVersion{}, fmt.Errorf("unrecognized proto version %q", output)
+	}
+
+	var nums [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return ProtoVersion{}, fmt.Errorf("unrecognized proto version %q", output)
+		}
+		nums[i] = n
+	}
+
+	return ProtoVersion{nums[0], nums[1], nums[2]}, nil
+}
+
+// checkProtoVersion reports proto releases older than protoSchema supports.
+func checkProtoVersion(version ProtoVersion) error {
+	if version.Less(protoSchema.MinVersion) {
+		return fmt.Errorf("proto %s is not supported, %s or newer is required", version, protoSchema.MinVersion)
+	}
+	return nil
+}
+
+var getProtoVersion = func(ctx context.Context, config ProtoConfig) (ProtoVersion, error) {
+	output, err := runProto(ctx, config, []string{"--version"})
+	if err != nil {
+		return ProtoVersion{}, err
+	}
+	return parseProtoVersion(string(output))
+}
+
+// cachedProtoVersion is the version of a proto executable, valid while the
+// executable matches its fingerprint.
+type cachedProtoVersion struct {
+	Binary  fileFingerprint `json:"binary"`
+	Version string          `json:"version"`
+}
+
+// detectProtoVersion returns the version of the proto executable config
+// runs, for doctor and explain; prompts never need it. The version is
+// stored in the cache file, keyed by the executable's path, so `proto
+// --version` only runs again once proto is replaced or upgraded.
+func detectProtoVersion(ctx context.Context, config ProtoConfig) (ProtoVersion, error) {
+	path, err := exec.LookPath(resolveProtoPath(config))
+	if err != nil {
+		return ProtoVersion{}, fmt.Errorf("cannot detect proto version: %w", err)
+	}
+	binary := statFingerprint(path)
+
+	cached, _ := readCache()
+	if known, ok := cached.ProtoVersions[path]; ok && known.Binary == binary {
+		if version, err := parseProtoVersion(known.Version); err == nil {
+			return version, checkProtoVersion(version)
+		}
+	}
+
+	version, err := getProtoVersion(ctx, config)
+	if err != nil {
+		return ProtoVersion{}, fmt.Errorf("cannot detect proto version: %w", err)
+	}
+
+	if cached.ProtoVersions == nil {
+		cached.ProtoVersions = make(map[string]cachedProtoVersion)
+	}
+	cached.ProtoVersions[path] = cachedProtoVersion{Binary: binary, Version: version.String()}
+	writeCache(cached)
+
+	return version, checkProtoVersion(version)
+}
+
+func (s ProtoSchema) DecodeStatus(data []byte) (map[string]ToolStatus, error) {
+	if err := s.checkShape("status", data, s.StatusFields, nil); err != nil {
+		return nil, err
+	}
+	if err := s.checkShape("status", data, s.InstalledFields, isInstalledEntry); err != nil {
+		return nil, err
+	}
+
+	var tools map[string]ToolStatus
+	if err := json.Unmarshal(data, &tools); err != nil {
+		return nil, err
+	}
+	return tools, nil
+}
+
+func (s ProtoSchema) DecodeOutdated(data []byte) (map[string]OutdatedStatus, error) {
+	if err := s.checkShape("outdated", data, s.OutdatedFields, nil); err != nil {
+		return nil, err
+	}
+
+	var tools map[string]OutdatedStatus
+	if err := json.Unmarshal(data, &tools); err != nil {
+		return nil, err
+	}
+	return tools, nil
+}
+
+func isInstalledEntry(entry map[string]json.RawMessage) bool {
+	return string(entry["is_installed"]) == "true"
+}
+
+// checkShape verifies that every entry, or every entry accepted by filter,
+// carries the required keys. An unexpected field name would otherwise decode
+// silently into an empty version.
+func (s ProtoSchema) checkShape(command string, data []byte, required []string, filter func(map[string]json.RawMessage) bool) error {
+	var entries map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return &SchemaError{Command: command}
+	}
+
+	tools := make([]string, 0, len(entries))
+	for tool := range entries {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+
+	for _, tool := range tools {
+		entry := entries[tool]
+		if filter != nil && !filter(entry) {
+			continue
+		}
+
+		var missing []string
+		for _, field := range required {
+			if _, ok := entry[field]; !ok {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		seen := make([]string, 0, len(entry))
+		for field := range entry {
+			seen = append(seen, field)
+		}
+		sort.Strings(seen)
+		return &SchemaError{Command: command, Tool: tool, Missing: missing, Seen: seen}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseProtoVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    ProtoVersion
+		wantErr bool
+	}{
+		{"proto prefix", "proto 0.45.2\n", ProtoVersion{0, 45, 2}, false},
+		{"bare version", "0.50.1", ProtoVersion{0, 50, 1}, false},
+		{"v prefix", "proto v1.0.0", ProtoVersion{1, 0, 0}, false},
+		{"prerelease", "proto 0.51.0-alpha.1", ProtoVersion{0, 51, 0}, false},
+		{"empty", "", ProtoVersion{}, true},
+		{"garbage", "proto nightly", ProtoVersion{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProtoVersion(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProtoVersion(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseProtoVersion(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckProtoVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version ProtoVersion
+		wantErr bool
+	}{
+		{"oldest supported", ProtoVersion{0, 37, 0}, false},
+		{"newer release", ProtoVersion{0, 50, 1}, false},
+		{"too old", ProtoVersion{0, 36, 9}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkProtoVersion(tt.version); (err != nil) != tt.wantErr {
+				t.Errorf("checkProtoVersion(%v) error = %v, wantErr %v", tt.version, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestProtoFixtures decodes the fixtures in testdata/proto with protoSchema.
+// They are written by hand in the shape of proto's output, one per version
+// the version check is tested with; they are not captured from releases.
+func TestProtoFixtures(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "proto", "*"))
+	if err != nil || len(dirs) == 0 {
+		t.Fatalf("No proto fixtures found: %v", err)
+	}
+
+	for _, dir := range dirs {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			versionOutput, err := os.ReadFile(filepath.Join(dir, "version.txt"))
+			if err != nil {
+				t.Fatalf("Failed to read version fixture: %v", err)
+			}
+			version, err := parseProtoVersion(string(versionOutput))
+			if err != nil {
+				t.Fatalf("parseProtoVersion() error = %v", err)
+			}
+			if err := checkProtoVersion(version); err != nil {
+				t.Fatalf("checkProtoVersion() error = %v", err)
+			}
+
+			statusOutput, _ := os.ReadFile(filepath.Join(dir, "status.json"))
+			tools, err := protoSchema.DecodeStatus(statusOutput)
+			if err != nil {
+				t.Fatalf("DecodeStatus() error = %v", err)
+			}
+			for tool, status := range tools {
+				if status.IsInstalled && status.ResolvedVersion == "" {
+					t.Errorf("%s: installed tool decoded without a resolved version", tool)
+				}
+				if status.ConfigVersion == "" {
+					t.Errorf("%s: decoded without a config version", tool)
+				}
+			}
+
+			outdatedOutput, _ := os.ReadFile(filepath.Join(dir, "outdated.json"))
+			outdated, err := protoSchema.DecodeOutdated(outdatedOutput)
+			if err != nil {
+				t.Fatalf("DecodeOutdated() error = %v", err)
+			}
+			for tool, out := range outdated {
+				if out.CurrentVersion == "" || out.NewestVersion == "" || out.LatestVersion == "" {
+					t.Errorf("%s: outdated entry decoded with empty versions: %+v", tool, out)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeUnknownShape(t *testing.T) {
+	schema := protoSchema
+
+	statusOutput, _ := os.ReadFile(filepath.Join("testdata", "schema", "renamed_status.json"))
+	if _, err := schema.DecodeStatus(statusOutput); err == nil {
+		t.Error("DecodeStatus() should reject a renamed resolved_version field")
+	} else {
+		var schemaErr *SchemaError
+		if !errors.As(err, &schemaErr) || schemaErr.Tool != "node" {
+			t.Errorf("DecodeStatus() error = %v, want SchemaError for node", err)
+		}
+	}
+
+	outdatedOutput, _ := os.ReadFile(filepath.Join("testdata", "schema", "renamed_outdated.json"))
+	if _, err := schema.DecodeOutdated(outdatedOutput); err == nil {
+		t.Error("DecodeOutdated() should reject renamed version fields")
+	}
+
+	if _, err := schema.DecodeStatus([]byte(`["node"]`)); err == nil {
+		t.Error("DecodeStatus() should reject a non-object document")
+	}
+}
+
+func TestDetectProtoVersion(t *testing.T) {
+	oldGetProtoVersion := getProtoVersion
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		getProtoVersion = oldGetProtoVersion
+		getCacheFile = oldGetCacheFile
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "config.cache.json")
+	getCacheFile = func() string { return cacheFile }
+
+	protoPath := filepath.Join(t.TempDir(), "proto")
+	os.WriteFile(protoPath, []byte("#!/bin/sh\n"), 0755)
+	config := ProtoConfig{Proto: ProtoExecConfig{Path: protoPath}}
+
+	calls := 0
+	getProtoVersion = func(ctx context.Context, config ProtoConfig) (ProtoVersion, error) {
+		calls++
+		return ProtoVersion{0, 45, 2}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		version, err := detectProtoVersion(context.Background(), config)
+		if err != nil {
+			t.Fatalf("detectProtoVersion() error = %v", err)
+		}
+		if version != (ProtoVersion{0, 45, 2}) {
+			t.Errorf("detectProtoVersion() = %v", version)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("proto --version ran %d times, want 1 with the version stored in the cache file", calls)
+	}
+
+	os.WriteFile(protoPath, []byte("#!/bin/sh\n# upgraded\n"), 0755)
+	getProtoVersion = func(ctx context.Context, config ProtoConfig) (ProtoVersion, error) {
+		calls++
+		return ProtoVersion{0, 36, 0}, nil
+	}
+	if _, err := detectProtoVersion(context.Background(), config); err == nil {
+		t.Error("detectProtoVersion() should report an unsupported release")
+	}
+	if calls != 2 {
+		t.Errorf("proto --version ran %d times, want it to run again after proto changed", calls)
+	}
+
+	getProtoVersion = func(ctx context.Context, config ProtoConfig) (ProtoVersion, error) {
+		return ProtoVersion{}, fmt.Errorf("boom")
+	}
+	os.WriteFile(protoPath, []byte("#!/bin/sh\n# broken\n"), 0755)
+	if _, err := detectProtoVersion(context.Background(), config); err == nil {
+		t.Error("detectProtoVersion() should report a failed detection")
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version as written by proto and its plugins. Partial
+// versions such as "22" or "22.1" are accepted; Parts records how many
+// numeric components were present.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	Pre   string
+	Build string
+	Parts int
+}
+
+// parseVersion parses a full or partial semantic version, tolerating a
+// leading "v". Aliases such as "lts" or "latest" are rejected.
+func parseVersion(s string) (Version, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if s == "" {
+		return Version{}, false
+	}
+
+	var v Version
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		v.Build = s[i+1:]
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		v.Pre = s[i+1:]
+		s = s[:i]
+		if v.Pre == "" {
+			return Version{}, false
+		}
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return Version{}, false
+	}
+	nums := [3]int{}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, false
+		}
+		nums[i] = n
+	}
+	v.Major, v.Minor, v.Patch = nums[0], nums[1], nums[2]
+	v.Parts = len(parts)
+	if v.Parts < 3 && (v.Pre != "" || v.Build != "") {
+		return Version{}, false
+	}
+	return v, true
+}
+
+func (v Version) String() string {
+	s := strconv.Itoa(v.Major)
+	if v.Parts >= 2 {
+		s += "." + strconv.Itoa(v.Minor)
+	}
+	if v.Parts >= 3 {
+		s += "." + strconv.Itoa(v.Patch)
+	}
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// compareVersions orders versions by semver precedence, returning -1, 0 or 1.
+// Missing components compare as zero and build metadata is ignored.
+func compareVersions(a, b Version) int {
+	for _, pair := range [][2]int{{a.Major, b.Major}, {a.Minor, b.Minor}, {a.Patch, b.Patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return comparePrerelease(a.Pre, b.Pre)
+}
+
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	aIDs, bIDs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aIDs) && i < len(bIDs); i++ {
+		aNum, aErr := strconv.Atoi(aIDs[i])
+		bNum, bErr := strconv.Atoi(bIDs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(aIDs[i], bIDs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(aIDs) < len(bIDs):
+		return -1
+	case len(aIDs) > len(bIDs):
+		return 1
+	}
+	return 0
+}
+
+// comparator is one bound of a version requirement.
+type comparator struct {
+	op      string // ">=", ">", "<", "<=", "="
+	version Version
+}
+
+// satisfies reports whether v matches a version requirement in the syntax
+// proto accepts: exact and partial versions, "=", ">", ">=", "<", "<=", "~",
+// "^", "*" and "x" wildcards, comma or space separated conjunctions and
+// "||" alternatives. A bare partial version such as "22.1" behaves like
+// "~22.1". Unrecognized requirements, like aliases, return an error.
+func satisfies(v Version, requirement string) (bool, error) {
+	for _, alternative := range strings.Split(requirement, "||") {
+		comparators, err := parseRequirement(alternative)
+		if err != nil {
+			return false, err
+		}
+		if matchesAll(v, comparators) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// validateRequirement reports whether requirement uses syntax satisfies
+// understands.
+func validateRequirement(requirement string) error {
+	for _, alternative := range strings.Split(requirement, "||") {
+		if _, err := parseRequirement(alternative); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchesAll(v Version, comparators []comparator) bool {
+	// Prereleases only match requirements that opt into the same release.
+	if v.Pre != "" {
+		optedIn := false
+		for _, c := range comparators {
+			if c.version.Pre != "" && c.version.Major == v.Major && c.version.Minor == v.Minor && c.version.Patch == v.Patch {
+				optedIn = true
+			}
+		}
+		if !optedIn {
+			return false
+		}
+	}
+
+	for _, c := range comparators {
+		cmp := compareVersions(v, c.version)
+		var ok bool
+		switch c.op {
+		case "=":
+			ok = cmp == 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func parseRequirement(requirement string) ([]comparator, error) {
+	fields := strings.FieldsFunc(requirement, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty version requirement")
+	}
+
+	// Join operators separated from their version by a space, e.g. ">= 20".
+	var tokens []string
+	for i := 0; i < len(fields); i++ {
+		if strings.Trim(fields[i], "<>=~^") == "" && i+1 < len(fields) {
+			tokens = append(tokens, fields[i]+fields[i+1])
+			i++
+			continue
+		}
+		tokens = append(tokens, fields[i])
+	}
+
+	var comparators []comparator
+	for _, token := range tokens {
+		c, err := parseComparator(token)
+		if err != nil {
+			return nil, err
+		}
+		comparators = append(comparators, c...)
+	}
+	return comparators, nil
+}
+
+func parseComparator(token string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "~", "^"} {
+		if strings.HasPrefix(token, prefix) {
+			op = prefix
+			break
+		}
+	}
+	raw := strings.TrimSpace(strings.TrimPrefix(token, op))
+
+	// Strip trailing wildcards: "22.x" and "22.*" are partial versions.
+	for _, suffix := range []string{".x", ".X", ".*"} {
+		for strings.HasSuffix(raw, suffix) {
+			raw = strings.TrimSuffix(raw, suffix)
+		}
+	}
+	if raw == "*" || raw == "x" || raw == "X" {
+		return nil, nil
+	}
+
+	v, ok := parseVersion(raw)
+	if !ok {
+		return nil, fmt.Errorf("unsupported version requirement %q", token)
+	}
+
+	lower := Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch, Pre: v.Pre, Parts: 3}
+	switch op {
+	case "":
+		if v.Parts == 3 {
+			return []comparator{{"=", lower}}, nil
+		}
+		return tildeRange(v, lower), nil
+	case "=":
+		if v.Parts == 3 {
+			return []comparator{{"=", lower}}, nil
+		}
+		return tildeRange(v, lower), nil
+	case "~":
+		return tildeRange(v, lower), nil
+	case "^":
+		return caretRange(v, lower), nil
+	case ">=":
+		return []comparator{{">=", lower}}, nil
+	case "<":
+		return []comparator{{"<", lower}}, nil
+	case ">":
+		if v.Parts == 3 {
+			return []comparator{{">", lower}}, nil
+		}
+		return []comparator{{">=", bumpPartial(v)}}, nil
+	case "<=":
+		if v.Parts == 3 {
+			return []comparator{{"<=", lower}}, nil
+		}
+		return []comparator{{"<", bumpPartial(v)}}, nil
+	}
+	return nil, fmt.Errorf("unsupported version requirement %q", token)
+}
+
+// bumpPartial returns the first version past a partial version, e.g. 1 -> 2.0.0
+// and 1.2 -> 1.3.0.
+func bumpPartial(v Version) Version {
+	if v.Parts == 1 {
+		return Version{Major: v.Major + 1, Parts: 3}
+	}
+	return Version{Major: v.Major, Minor: v.Minor + 1, Parts: 3}
+}
+
+func tildeRange(v, lower Version) []comparator {
+	upper := Version{Major: v.Major, Minor: v.Minor + 1, Parts: 3}
+	if v.Parts == 1 {
+		upper = Version{Major: v.Major + 1, Parts: 3}
+	}
+	return []comparator{{">=", lower}, {"<", upper}}
+}
+
+func caretRange(v, lower Version) []comparator {
+	var upper Version
+	switch {
+	case v.Major > 0 || v.Parts == 1:
+		upper = Version{Major: v.Major + 1, Parts: 3}
+	case v.Minor > 0 || v.Parts == 2:
+		upper = Version{Minor: v.Minor + 1, Parts: 3}
+	default:
+		upper = Version{Patch: v.Patch + 1, Parts: 3}
+	}
+	return []comparator{{">=", lower}, {"<", upper}}
+}
+
+// Kinds of update between two versions, by the most significant component
+// that changes.
+const (
+	UpdateMajor      = "major"
+	UpdateMinor      = "minor"
+	UpdatePatch      = "patch"
+	UpdatePrerelease = "prerelease"
+	UpdateNone       = "none"
+)
+
+// updateType classifies the update from one version to another. It returns
+// "none" unless both are full versions and to is newer, so aliases and
+// partial versions proto could not resolve never look like an update.
+func updateType(from, to string) string {
+	fromVersion, ok := parseFullVersion(from)
+	if !ok {
+		return UpdateNone
+	}
+	toVersion, ok := parseFullVersion(to)
+	if !ok || compareVersions(toVersion, fromVersion) <= 0 {
+		return UpdateNone
+	}
+
+	switch {
+	case toVersion.Major != fromVersion.Major:
+		return UpdateMajor
+	case toVersion.Minor != fromVersion.Minor:
+		return UpdateMinor
+	case toVersion.Patch != fromVersion.Patch:
+		return UpdatePatch
+	default:
+		return UpdatePrerelease
+	}
+}
+
+// majorsBehind counts the major versions from one version to a newer one,
+// 0 if either is not a full version.
+func majorsBehind(from, to string) int {
+	fromVersion, ok := parseFullVersion(from)
+	if !ok {
+		return 0
+	}
+	toVersion, ok := parseFullVersion(to)
+	if !ok || toVersion.Major < fromVersion.Major {
+		return 0
+	}
+	return toVersion.Major - fromVersion.Major
+}
+
+// parseFullVersion parses a version with all three components.
+func parseFullVersion(s string) (Version, bool) {
+	v, ok := parseVersion(s)
+	return v, ok && v.Parts == 3
+}
@@ -0,0 +1,149 @@
+package main
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   Version
+		wantOK bool
+	}{
+		{"22.12.0", Version{Major: 22, Minor: 12, Patch: 0, Parts: 3}, true},
+		{"v1.23.4", Version{Major: 1, Minor: 23, Patch: 4, Parts: 3}, true},
+		{"22", Version{Major: 22, Parts: 1}, true},
+		{"22.1", Version{Major: 22, Minor: 1, Parts: 2}, true},
+		{"1.2.0-rc.1+build.5", Version{Major: 1, Minor: 2, Pre: "rc.1", Build: "build.5", Parts: 3}, true},
+		{"lts", Version{}, false},
+		{"latest", Version{}, false},
+		{"1.2.3.4", Version{}, false},
+		{"22-rc", Version{}, false},
+		{"", Version{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := parseVersion(tt.input)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("parseVersion(%q) = %+v, %v, want %+v, %v", tt.input, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0-alpha.2", "1.0.0-alpha.10", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"1.0.0+a", "1.0.0+b", 0},
+		{"22", "22.0.0", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			a, _ := parseVersion(tt.a)
+			b, _ := parseVersion(tt.b)
+			if got := compareVersions(a, b); got != tt.want {
+				t.Errorf("compareVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	tests := []struct {
+		version     string
+		requirement string
+		want        bool
+	}{
+		{"22.12.0", "22.12.0", true},
+		{"22.12.1", "22.12.0", false},
+		{"22.12.0", "22", true},
+		{"23.0.0", "22", false},
+		{"22.1.5", "22.1", true},
+		{"22.2.0", "22.1", false},
+		{"22.1.5", "~22.1", true},
+		{"22.1.5", "~22.1.6", false},
+		{"22.9.0", "^22.1", true},
+		{"23.0.0", "^22.1", false},
+		{"0.2.9", "^0.2.3", true},
+		{"0.3.0", "^0.2.3", false},
+		{"0.0.4", "^0.0.3", false},
+		{"20.0.0", ">=20 <23", true},
+		{"23.0.0", ">=20, <23", false},
+		{"2.0.0", ">1", true},
+		{"1.9.0", ">1", false},
+		{"1.2.9", "<=1.2", true},
+		{"1.3.0", "<=1.2", false},
+		{"1.5.0", "1.x", true},
+		{"9.9.9", "*", true},
+		{"18.0.0", "^20 || ^18", true},
+		{"19.0.0", "^20 || ^18", false},
+		{"1.0.0-rc.1", "^1", false},
+		{"1.0.0-rc.2", ">=1.0.0-rc.1", true},
+		{"20.0.0", ">= 20", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version+" "+tt.requirement, func(t *testing.T) {
+			v, _ := parseVersion(tt.version)
+			got, err := satisfies(v, tt.requirement)
+			if err != nil {
+				t.Fatalf("satisfies() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("satisfies(%s, %q) = %v, want %v", tt.version, tt.requirement, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSatisfiesAlias(t *testing.T) {
+	v, _ := parseVersion("22.0.0")
+	for _, alias := range []string{"lts", "latest", "stable", ""} {
+		if _, err := satisfies(v, alias); err == nil {
+			t.Errorf("satisfies(%q) should reject aliases", alias)
+		}
+	}
+}
+
+func TestUpdateType(t *testing.T) {
+	tests := []struct {
+		from, to   string
+		want       string
+		wantBehind int
+	}{
+		{"20.11.0", "22.1.0", UpdateMajor, 2},
+		{"22.1.0", "22.3.0", UpdateMinor, 0},
+		{"22.1.0", "22.1.4", UpdatePatch, 0},
+		{"1.2.0-rc.1", "1.2.0", UpdatePrerelease, 0},
+		{"1.2.0-rc.1", "1.2.0-rc.2", UpdatePrerelease, 0},
+		{"22.1.0", "22.1.0", UpdateNone, 0},
+		{"22.1.0", "21.0.0", UpdateNone, 0},
+		{"22", "22.12.0", UpdateNone, 0},
+		{"20", "22.1.0", UpdateNone, 0},
+		{"22.1.0", "23", UpdateNone, 0},
+		{"v1.22.0", "1.23.0", UpdateMinor, 0},
+		{"lts", "22.1.0", UpdateNone, 0},
+		{"22.1.0", "latest", UpdateNone, 0},
+		{"", "22.1.0", UpdateNone, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			if got := updateType(tt.from, tt.to); got != tt.want {
+				t.Errorf("updateType(%q, %q) = %q, want %q", tt.from, tt.to, got, tt.want)
+			}
+			if got := majorsBehind(tt.from, tt.to); got != tt.wantBehind {
+				t.Errorf("majorsBehind(%q, %q) = %d, want %d", tt.from, tt.to, got, tt.wantBehind)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// Sort modes for the order of tools in the output.
+const (
+	SortAlphabetical = "alphabetical" // By tool ID
+	SortConfig       = "config"       // As listed in "tools"
+	SortPrototools   = "prototools"   // As pinned in .prototools, closest file first
+	SortAttention    = "attention"    // Outdated, then missing, then the rest
+	SortSource       = "source"       // Local, then inherited, then global
+)
+
+var sortModes = []string{SortAlphabetical, SortConfig, SortPrototools, SortAttention, SortSource}
+
+func getSortMode(config ProtoConfig) string {
+	if config.Sort == "" {
+		return SortAlphabetical
+	}
+	return config.Sort
+}
+
+// sortTools orders alphabetically sorted tool IDs by the configured sort
+// mode. Tools the mode does not rank keep their alphabetical order, after
+// the ranked ones.
+func sortTools(toolNames []string, data map[string]TemplateData, config ProtoConfig, wd, homeDir string) {
+	var rank func(tool string) int
+	switch getSortMode(config) {
+	case SortConfig:
+		rank = indexRank(config.toolOrder, func(tool string) []string {
+			_, name := parseToolID(tool)
+			return []string{tool, name}
+		})
+	case SortPrototools:
+		var pinned []string
+		if _, files, err := loadConfiguredVersions(wd, homeDir, config); err == nil {
+			for _, file := range files {
+				for _, tool := range file.ToolOrder {
+					if !containsString(pinned, tool) {
+						pinned = append(pinned, tool)
+					}
+				}
+			}
+		}
+		rank = indexRank(pinned, func(tool string) []string { return []string{tool} })
+	case SortAttention:
+		rank = func(tool string) int {
+			switch data[tool].State {
+			case StateOutdated:
+				return 0
+			case StateMissing:
+				return 1
+			default:
+				return 2
+			}
+		}
+	case SortSource:
+		rank = func(tool string) int {
+			switch d := data[tool]; {
+			case d.IsLocal:
+				return 0
+			case d.IsInherited:
+				return 1
+			case d.IsGlobal:
+				return 2
+			default:
+				return 3
+			}
+		}
+	default:
+		return
+	}
+
+	sort.SliceStable(toolNames, func(i, j int) bool {
+		return rank(toolNames[i]) < rank(toolNames[j])
+	})
+}
+
+// indexRank ranks a tool by the position of the first of its keys in
+// order, and unlisted tools after all listed ones.
+func indexRank(order []string, keys func(tool string) []string) func(string) int {
+	index := make(map[string]int, len(order))
+	for i, key := range order {
+		index[key] = i
+	}
+	return func(tool string) int {
+		for _, key := range keys(tool) {
+			if i, ok := index[key]; ok {
+				return i
+			}
+		}
+		return len(order)
+	}
+}
+
+// objectKeys returns the keys of the object at field in a JSON object, in
+// the order they are written, which decoding into a map loses.
+func objectKeys(data []byte, field string) []string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if key != field {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil
+			}
+			continue
+		}
+
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return nil
+		}
+		var keys []string
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return keys
+			}
+			if name, ok := tok.(string); ok {
+				keys = append(keys, name)
+			}
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return keys
+			}
+		}
+		return keys
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestObjectKeys(t *testing.T) {
+	data := []byte(`{"template": "{{.Tool}}", "tools": {"rust": {"icon": "e7a8"}, "go": {"order": 1}, "asdf:terraform": {}}, "cache": {"ttl": 1}}`)
+	if got := objectKeys(data, "tools"); !reflect.DeepEqual(got, []string{"rust", "go", "asdf:terraform"}) {
+		t.Errorf("objectKeys() = %v", got)
+	}
+	if got := objectKeys(data, "backends"); got != nil {
+		t.Errorf("objectKeys() of a missing field = %v, want nil", got)
+	}
+	if got := objectKeys([]byte(`{"tools": []}`), "tools"); got != nil {
+		t.Errorf("objectKeys() of an array = %v, want nil", got)
+	}
+}
+
+func TestFormatOutputSort(t *testing.T) {
+	oldGetCacheFile := getCacheFile
+	defer func() { getCacheFile = oldGetCacheFile }()
+	cacheFile := filepath.Join(t.TempDir(), "config.cache.json")
+	getCacheFile = func() string { return cacheFile }
+
+	home := t.TempDir()
+	protoHome := filepath.Join(home, ".proto")
+	project := filepath.Join(home, "project", "app")
+	os.MkdirAll(protoHome, 0755)
+	os.MkdirAll(project, 0755)
+	t.Setenv("HOME", home)
+	t.Setenv("PROTO_HOME", protoHome)
+	t.Chdir(project)
+
+	os.WriteFile(filepath.Join(project, ".prototools"), []byte("node = \"22\"\nrust = \"1.80\"\n"), 0644)
+	os.WriteFile(filepath.Join(home, "project", ".prototools"), []byte("go = \"1.22\"\nnode = \"20\"\n"), 0644)
+
+	tools := map[string]ToolStatus{
+		"bun":            {IsInstalled: true, ResolvedVersion: "1.1.0", ConfigSource: filepath.Join(protoHome, ".prototools")},
+		"go":             {IsInstalled: true, ResolvedVersion: "1.22.0", ConfigSource: filepath.Join(home, "project", ".prototools")},
+		"node":           {IsInstalled: true, ResolvedVersion: "22.1.0", ConfigSource: filepath.Join(project, ".prototools")},
+		"rust":           {ConfigSource: filepath.Join(project, ".prototools")},
+		"asdf:terraform": {IsInstalled: true, ResolvedVersion: "1.9.0"},
+	}
+	outdated := map[string]OutdatedStatus{
+		"bun":            {},
+		"go":             {IsOutdated: true, NewestVersion: "1.23.0", LatestVersion: "1.23.0"},
+		"node":           {},
+		"asdf:terraform": {},
+	}
+
+	configJSON := []byte(`{"tools": {"node": {}, "terraform": {}, "go": {}}}`)
+
+	tests := []struct {
+		name     string
+		sort     string
+		order    map[string]int
+		expected string
+	}{
+		{"default", "", nil, "asdf:terraform bun go node rust"},
+		{"alphabetical", SortAlphabetical, nil, "asdf:terraform bun go node rust"},
+		{"config", SortConfig, nil, "node asdf:terraform go bun rust"},
+		{"prototools", SortPrototools, nil, "node rust go asdf:terraform bun"},
+		{"attention", SortAttention, nil, "go rust asdf:terraform bun node"},
+		{"source", SortSource, nil, "node rust go bun asdf:terraform"},
+		{"order wins", SortSource, map[string]int{"bun": 1}, "bun node rust go asdf:terraform"},
+		{"unknown", "random", nil, "asdf:terraform bun go node rust"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ProtoConfig{
+				Template:  "{{.Tool}}{{if not .IsLast}} {{end}}",
+				Sort:      tt.sort,
+				Tools:     map[string]IconConfig{},
+				toolOrder: objectKeys(configJSON, "tools"),
+			}
+			for tool, order := range tt.order {
+				config.Tools[tool] = IconConfig{Order: order}
+			}
+
+			output := formatOutput(tools, outdated, config, FetchInfo{})
+			if output != tt.expected {
+				t.Errorf("formatOutput() = %q, want %q", output, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadJSONConfigToolOrder(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.jsonc")
+	os.WriteFile(configFile, []byte("{\n\t// Comment\n\t\"sort\": \"config\",\n\t\"tools\": {\"zig\": {}, \"bun\": {},},\n}"), 0644)
+
+	config, err := loadJSONConfig(configFile)
+	if err != nil {
+		t.Fatalf("loadJSONConfig() error = %v", err)
+	}
+	if config.Sort != SortConfig || !reflect.DeepEqual(config.toolOrder, []string{"zig", "bun"}) {
+		t.Errorf("loadJSONConfig() sort = %q, tool order = %v", config.Sort, config.toolOrder)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tool states, in the order they are checked.
+const (
+	StateMissing  = "missing"
+	StateUnknown  = "unknown"
+	StateLatest   = "latest"
+	StateNewest   = "newest"
+	StateOutdated = "outdated"
+)
+
+var toolStates = []string{StateMissing, StateUnknown, StateLatest, StateNewest, StateOutdated}
+
+// StateStyle describes how a tool in one state is displayed, as an
+// alternative to writing a template.
+type StateStyle struct {
+	Color  string `json:"color,omitempty"`  // Color of the whole segment
+	Icon   string `json:"icon,omitempty"`   // Replaces the tool icon, Nerd Font hex code
+	Format string `json:"format,omitempty"` // Text with {icon}, {tool}, {label}, {version}, {config}, {newest}, {latest}
+}
+
+// defaultStateStyles fill in the color or format of a state when only the
+// other one is configured.
+var defaultStateStyles = map[string]StateStyle{
+	StateMissing:  {Color: "red", Format: "{icon} Missing"},
+	StateUnknown:  {Format: "{icon} {version}?"},
+	StateLatest:   {Color: "green", Format: "{icon} {version}"},
+	StateNewest:   {Color: "cyan", Format: "{icon} {version}"},
+	StateOutdated: {Color: "#4a5568", Format: "{icon} {version} → {newest}"},
+}
+
+// defaultStateParts render a state with neither color nor format
+// configured the way the default template does, one color per part.
+var defaultStateParts = map[string][]StateStyle{
+	StateMissing:  {{Format: "{icon} "}, {Color: "red", Format: "Missing"}},
+	StateUnknown:  {{Format: "{icon} {version}"}, {Color: "#4a5568", Format: "?"}},
+	StateLatest:   {{Format: "{icon} "}, {Color: "green", Format: "{version}"}},
+	StateNewest:   {{Format: "{icon} "}, {Color: "cyan", Format: "{version}"}},
+	StateOutdated: {{Format: "{icon} "}, {Color: "#4a5568", Format: "{version}"}, {Format: " "}, {Color: "white", Format: "→"}, {Format: " "}, {Color: "cyan", Format: "{newest}"}},
+}
+
+// toolState classifies a tool the same way the default template does.
+func toolState(data TemplateData) string {
+	switch {
+	case !data.IsInstalled:
+		return StateMissing
+	case data.OutdatedUnknown:
+		return StateUnknown
+	case data.ResolvedVersion == data.LatestVersion:
+		return StateLatest
+	case data.ResolvedVersion == data.NewestVersion:
+		return StateNewest
+	default:
+		return StateOutdated
+	}
+}
+
+var statePlaceholders = map[string]string{
+	"tool":    "{{.Tool}}",
+	"label":   "{{.Label}}",
+	"version": "{{.ResolvedVersion}}",
+	"config":  "{{.ConfigVersion}}",
+	"newest":  "{{.NewestVersion}}",
+	"latest":  "{{.LatestVersion}}",
+}
+
+// toolTemplate returns the template each tool is rendered with: template,
+// else the states, else the default. A template equal to the default, as
+// written by the generated config, counts as unset so states still apply.
+func toolTemplate(config ProtoConfig) string {
+	if config.Template != "" && (config.Template != defaultTemplate || len(config.States) == 0) {
+		return config.Template
+	}
+	if len(config.States) > 0 {
+		return compileStatesTemplate(config.States)
+	}
+	return defaultTemplate
+}
+
+// compileStatesTemplate turns the states config into a per-tool template.
+// States without a color or format look like the default template; fields
+// left empty next to a configured one use defaultStateStyles.
+func compileStatesTemplate(states map[string]StateStyle) string {
+	var b strings.Builder
+	for i, state := range toolStates {
+		if i == 0 {
+			fmt.Fprintf(&b, "{{if eq .State %q}}", state)
+		} else {
+			fmt.Fprintf(&b, "{{else if eq .State %q}}", state)
+		}
+
+		configured := states[state]
+		if configured.Color == "" && configured.Format == "" {
+			for _, part := range defaultStateParts[state] {
+				part.Icon = configured.Icon
+				b.WriteString(compileStateStyle(part))
+			}
+			continue
+		}
+
+		style := defaultStateStyles[state]
+		if configured.Color != "" {
+			style.Color = configured.Color
+		}
+		if configured.Icon != "" {
+			style.Icon = configured.Icon
+		}
+		if configured.Format != "" {
+			style.Format = configured.Format
+		}
+		b.WriteString(compileStateStyle(style))
+	}
+	b.WriteString("{{end}}  ")
+	return b.String()
+}
+
+func compileStateStyle(style StateStyle) string {
+	color := ""
+	if style.Color != "" {
+		color = fmt.Sprintf("{{fgColor %q}}", style.Color)
+	}
+
+	// The tool icon carries its own color and reset, so the state color is
+	// applied again after it.
+	icon := "{{.ToolIcon}}" + color
+	if style.Icon != "" {
+		icon = fmt.Sprintf("{{%q}}", decodeUnicodeHex(style.Icon))
+	}
+
+	var b strings.Builder
+	b.WriteString(color)
+	format := style.Format
+	for format != "" {
+		start := strings.IndexByte(format, '{')
+		length := -1
+		if start >= 0 {
+			length = strings.IndexByte(format[start:], '}')
+		}
+		if length < 0 {
+			b.WriteString(quoteTemplateText(format))
+			break
+		}
+		end := start + length
+
+		b.WriteString(quoteTemplateText(format[:start]))
+		name := format[start+1 : end]
+		if name == "icon" {
+			b.WriteString(icon)
+		} else if action, ok := statePlaceholders[name]; ok {
+			b.WriteString(action)
+		} else {
+			b.WriteString(quoteTemplateText(format[start : end+1]))
+		}
+		format = format[end+1:]
+	}
+	if color != "" {
+		b.WriteString("{{reset}}")
+	}
+	return b.String()
+}
+
+// quoteTemplateText makes literal text safe to embed in a template. Braces
+// are quoted, as a single one next to an action would change its meaning.
+func quoteTemplateText(text string) string {
+	if !strings.ContainsAny(text, "{}") {
+		return text
+	}
+	return fmt.Sprintf("{{%q}}", text)
+}
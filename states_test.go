@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestToolState(t *testing.T) {
+	tests := []struct {
+		name string
+		data TemplateData
+		want string
+	}{
+		{"missing", TemplateData{}, StateMissing},
+		{"unknown", TemplateData{IsInstalled: true, OutdatedUnknown: true}, StateUnknown},
+		{"latest", TemplateData{IsInstalled: true, ResolvedVersion: "2.0.0", NewestVersion: "2.0.0", LatestVersion: "2.0.0"}, StateLatest},
+		{"newest", TemplateData{IsInstalled: true, ResolvedVersion: "1.9.0", NewestVersion: "1.9.0", LatestVersion: "2.0.0"}, StateNewest},
+		{"outdated", TemplateData{IsInstalled: true, ResolvedVersion: "1.8.0", NewestVersion: "1.9.0", LatestVersion: "2.0.0"}, StateOutdated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toolState(tt.data); got != tt.want {
+				t.Errorf("toolState() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatOutputStates(t *testing.T) {
+	tools := map[string]ToolStatus{
+		"bun":  {IsInstalled: true, ResolvedVersion: "1.1.0"},
+		"go":   {IsInstalled: true, ResolvedVersion: "1.22.0"},
+		"node": {},
+	}
+	outdated := map[string]OutdatedStatus{
+		"bun": {},
+		"go":  {IsOutdated: true, NewestVersion: "1.23.0", LatestVersion: "1.23.0"},
+	}
+
+	config := ProtoConfig{
+		Tools: map[string]IconConfig{"bun": {Label: "Bun"}},
+		States: map[string]StateStyle{
+			StateLatest:   {Format: "{label} ok"},
+			StateOutdated: {Color: "", Icon: "f062", Format: "{icon}{tool} {version}->{newest} {{raw}}"},
+			StateMissing:  {Color: "yellow"},
+		},
+	}
+
+	output := formatOutput(tools, outdated, config, FetchInfo{})
+
+	want := "\x1b[32mBun ok\x1b[0m  " +
+		"\x1b[38;5;60m\uf062go 1.22.0->1.23.0 {{raw}}\x1b[0m  " +
+		"\x1b[33mnode\x1b[33m Missing\x1b[0m"
+	if output != want {
+		t.Errorf("formatOutput() = %q, want %q", output, want)
+	}
+
+	config.Template = defaultTemplate
+	if output := formatOutput(tools, outdated, config, FetchInfo{}); output != want {
+		t.Errorf("formatOutput() with the default template = %q, want the states", output)
+	}
+
+	config.Template = "{{.State}} "
+	if output := formatOutput(tools, outdated, config, FetchInfo{}); output != "latest outdated missing" {
+		t.Errorf("formatOutput() with template = %q, want the template to win", output)
+	}
+}
+
+func TestFormatOutputUnsetStatesMatchDefaultTemplate(t *testing.T) {
+	tools := map[string]ToolStatus{
+		"bun":  {IsInstalled: true, ResolvedVersion: "1.1.0"},
+		"deno": {IsInstalled: true, ResolvedVersion: "2.0.0"},
+		"go":   {IsInstalled: true, ResolvedVersion: "1.22.0"},
+		"node": {},
+		"rust": {IsInstalled: true, ResolvedVersion: "1.80.0"},
+	}
+	outdated := map[string]OutdatedStatus{
+		"bun":  {NewestVersion: "1.1.0", LatestVersion: "1.1.0"},
+		"deno": {NewestVersion: "2.0.0", LatestVersion: "2.1.0"},
+		"go":   {IsOutdated: true, NewestVersion: "1.23.0", LatestVersion: "1.23.0"},
+		"rust": {NewestVersion: "1.80.0", LatestVersion: "1.80.0"},
+	}
+
+	for _, info := range []FetchInfo{{}, {OutdatedUnknown: true}} {
+		want := formatOutput(tools, outdated, ProtoConfig{}, info)
+		config := ProtoConfig{States: map[string]StateStyle{StateLatest: {}}}
+		if output := formatOutput(tools, outdated, config, info); output != want {
+			t.Errorf("formatOutput() with unset states = %q, want the default template's %q", output, want)
+		}
+	}
+}
+
+func TestCompileStatesTemplateParses(t *testing.T) {
+	formats := []string{"", "{", "}", "{{", "}}", "{unknown}", "{{.Tool}}", "a{b{c}d}e", "{icon"}
+	for _, format := range formats {
+		tmplStr := compileStatesTemplate(map[string]StateStyle{StateLatest: {Format: format}})
+		if _, err := parseTemplate(tmplStr); err != nil {
+			t.Errorf("format %q: parseTemplate() error = %v", format, err)
+		}
+	}
+}
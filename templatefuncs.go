@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// Template helpers. Functions that take the piped value expect it as their
+// last argument, so `{{.ResolvedVersion | trimPrefix "v" | truncate 8}}`
+// works like in other Go template libraries.
+
+// semverCompare returns -1, 0 or 1. Values that are not versions, such as
+// aliases, are compared as strings.
+func semverCompare(a, b string) int {
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+	return compareVersions(va, vb)
+}
+
+// semverMajor, semverMinor and semverPatch return 0 for values that are not
+// versions.
+func semverMajor(v string) int {
+	version, _ := parseVersion(v)
+	return version.Major
+}
+
+func semverMinor(v string) int {
+	version, _ := parseVersion(v)
+	return version.Minor
+}
+
+func semverPatch(v string) int {
+	version, _ := parseVersion(v)
+	return version.Patch
+}
+
+// templateSatisfies reports whether v matches a proto version requirement
+// such as "^22" or ">=1.20 <2". Invalid input never matches.
+func templateSatisfies(v, requirement string) bool {
+	version, ok := parseVersion(v)
+	if !ok {
+		return false
+	}
+	matched, err := satisfies(version, requirement)
+	return err == nil && matched
+}
+
+func isPrerelease(v string) bool {
+	version, ok := parseVersion(v)
+	return ok && version.Pre != ""
+}
+
+// truncate shortens s to length characters.
+func truncate(length int, s string) string {
+	if length < 0 || utf8.RuneCountInString(s) <= length {
+		return s
+	}
+	return string([]rune(s)[:length])
+}
+
+// padRight pads s with spaces to width characters.
+func padRight(width int, s string) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+// defaultValue returns fallback when value is empty: "", 0, false, nil or
+// an empty list.
+func defaultValue(fallback, value any) any {
+	if value == nil {
+		return fallback
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		if rv.Len() == 0 {
+			return fallback
+		}
+	default:
+		if rv.IsZero() {
+			return fallback
+		}
+	}
+	return value
+}
+
+// join joins the elements of a list with sep.
+func join(sep string, list any) string {
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return fmt.Sprint(list)
+	}
+	parts := make([]string, rv.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(parts, sep)
+}
+
+func templateTrimPrefix(prefix, s string) string {
+	return strings.TrimPrefix(s, prefix)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	t.Setenv("OMP_PROTOTOOLS_TEST", "set")
+
+	data := map[string]any{
+		"Resolved": "22.12.0",
+		"Newest":   "22.13.1",
+		"Pre":      "1.2.0-rc.1",
+		"Empty":    "",
+		"List":     []string{"node", "go"},
+	}
+
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{`{{semverCompare .Resolved .Newest}}`, "-1"},
+		{`{{semverCompare "1.10.0" "1.9.0"}}`, "1"},
+		{`{{semverCompare "lts" "lts"}}`, "0"},
+		{`{{major .Resolved}}.{{minor .Resolved}}.{{patch .Resolved}}`, "22.12.0"},
+		{`{{major "latest"}}`, "0"},
+		{`{{satisfies .Resolved "^22"}}`, "true"},
+		{`{{satisfies .Resolved ">=23"}}`, "false"},
+		{`{{satisfies "lts" "^22"}}`, "false"},
+		{`{{satisfies .Resolved "not a range"}}`, "false"},
+		{`{{isPrerelease .Pre}} {{isPrerelease .Resolved}}`, "true false"},
+		{`{{"v1.2.3" | trimPrefix "v"}}`, "1.2.3"},
+		{`{{.Newest | truncate 5}}`, "22.13"},
+		{`{{"ü" | truncate 5}}`, "ü"},
+		{`[{{"go" | padRight 4}}]`, "[go  ]"},
+		{`{{upper "node"}}`, "NODE"},
+		{`{{.Empty | default "?"}} {{.Resolved | default "?"}}`, "? 22.12.0"},
+		{`{{0 | default 3}} {{false | default "no"}}`, "3 no"},
+		{`{{join ", " .List}}`, "node, go"},
+		{`{{env "OMP_PROTOTOOLS_TEST"}}`, "set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			tmpl, err := parseTemplate(tt.tmpl)
+			if err != nil {
+				t.Fatalf("parseTemplate() error = %v", err)
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, data); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
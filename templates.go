@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// getTemplatesDir returns {config_name}.templates next to the config file.
+// Each *.tmpl file in it is a partial named after the file.
+func getTemplatesDir(configFile string) string {
+	configBase := filepath.Base(configFile)
+	configName := strings.TrimSuffix(configBase, filepath.Ext(configBase))
+	return filepath.Join(filepath.Dir(configFile), configName+".templates")
+}
+
+// resolveTemplateFile returns the path of template_file, relative to the
+// config file unless absolute.
+func resolveTemplateFile(templateFile, configFile string) string {
+	path := expandPath(templateFile)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(configFile), path)
+	}
+	return path
+}
+
+// reservedPartials are the names templates are parsed under: "output" for
+// template and the other top-level templates, and "tool" for template when
+// used from layout_template. A partial of the same name would be replaced.
+var reservedPartials = []string{"output", "tool"}
+
+// loadTemplates reads template_file into Template and parses the partials
+// of the templates directory and the "templates" map, which wins over a
+// file of the same name. Partials are parsed once here; templates are
+// parsed on top of a clone. The files read are recorded so that editing
+// one invalidates the config and render caches.
+func loadTemplates(config *ProtoConfig, configFile string) error {
+	config.templateSources = nil
+	partials := template.New("partials").Funcs(templateFuncs())
+
+	templatesDir := getTemplatesDir(configFile)
+	config.templateSources = append(config.templateSources, statFingerprint(templatesDir))
+	files, _ := filepath.Glob(filepath.Join(templatesDir, "*.tmpl"))
+	sort.Strings(files)
+	for _, file := range files {
+		text, err := readTemplateFile(file)
+		if err != nil {
+			return err
+		}
+		config.templateSources = append(config.templateSources, statFingerprint(file))
+		name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+		if containsString(reservedPartials, name) {
+			return fmt.Errorf("%s: partial name %q is reserved", file, name)
+		}
+		if _, inline := config.Templates[name]; inline {
+			continue
+		}
+		if _, err := partials.New(name).Parse(text); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	names := make([]string, 0, len(config.Templates))
+	for name := range config.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if containsString(reservedPartials, name) {
+			return fmt.Errorf("templates.%s: partial name %q is reserved", name, name)
+		}
+		if _, err := partials.New(name).Parse(config.Templates[name]); err != nil {
+			return fmt.Errorf("templates.%s: %w", name, err)
+		}
+	}
+
+	// Partials may call "tool" when used from layout_template.
+	if err := checkTemplateRefs(partials, "tool"); err != nil {
+		return err
+	}
+	config.partials = partials
+
+	if config.TemplateFile != "" {
+		file := resolveTemplateFile(config.TemplateFile, configFile)
+		text, err := readTemplateFile(file)
+		if err != nil {
+			return err
+		}
+		config.templateSources = append(config.templateSources, statFingerprint(file))
+		if err := validateTemplate(*config, text); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		config.Template = text
+	}
+
+	return nil
+}
+
+// readTemplateFile reads a template, dropping the final newline editors
+// add, which would otherwise end up in the prompt.
+func readTemplateFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	text := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(text, "\r"), nil
+}
+
+// templateSourcesChanged reports whether a template file or the templates
+// directory changed since the config was loaded.
+func templateSourcesChanged(config ProtoConfig) bool {
+	for _, source := range config.templateSources {
+		if statFingerprint(source.Path) != source {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfigTemplate parses a template of config with its partials.
+func parseConfigTemplate(config ProtoConfig, tmplStr string) (*template.Template, error) {
+	if config.partials == nil {
+		return parseTemplate(tmplStr)
+	}
+	tmpl, err := config.partials.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.New("output").Parse(tmplStr)
+}
+
+// validateTemplate parses a template of config and checks that every
+// {{template}} it calls is defined, besides the names in provided.
+func validateTemplate(config ProtoConfig, tmplStr string, provided ...string) error {
+	tmpl, err := parseConfigTemplate(config, tmplStr)
+	if err != nil {
+		return err
+	}
+	return checkTemplateRefs(tmpl, provided...)
+}
+
+// checkTemplateRefs reports the first {{template "name"}} call to a
+// template that is neither defined nor in provided. text/template only
+// notices these when the call executes.
+func checkTemplateRefs(tmpl *template.Template, provided ...string) error {
+	templates := tmpl.Templates()
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name() < templates[j].Name() })
+	for _, t := range templates {
+		if t.Tree == nil {
+			continue
+		}
+		for _, name := range templateCalls(t.Tree.Root, nil) {
+			if tmpl.Lookup(name) == nil && !containsString(provided, name) {
+				return fmt.Errorf("template %q: no template named %q", t.Name(), name)
+			}
+		}
+	}
+	return nil
+}
+
+func templateCalls(node parse.Node, calls []string) []string {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return calls
+		}
+		for _, child := range n.Nodes {
+			calls = templateCalls(child, calls)
+		}
+	case *parse.IfNode:
+		calls = templateCalls(n.ElseList, templateCalls(n.List, calls))
+	case *parse.RangeNode:
+		calls = templateCalls(n.ElseList, templateCalls(n.List, calls))
+	case *parse.WithNode:
+		calls = templateCalls(n.ElseList, templateCalls(n.List, calls))
+	case *parse.TemplateNode:
+		calls = append(calls, n.Name)
+	}
+	return calls
+}
+
+// partialNames lists the partials of config, for doctor and explain.
+func partialNames(config ProtoConfig) []string {
+	if config.partials == nil {
+		return nil
+	}
+	var names []string
+	for _, t := range config.partials.Templates() {
+		if t.Tree != nil {
+			names = append(names, t.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// templateEnvVars lists the variables read by {{env "NAME"}} calls in the
+// templates of config, so the fast path can fingerprint them. ok is false
+// when a call reads a name that is not a constant, which makes the output
+// depend on variables that cannot be known up front.
+func templateEnvVars(config ProtoConfig) (vars []string, ok bool) {
+	tmplStrs := []string{config.Template, config.LayoutTemplate}
+	for _, tools := range []map[string]IconConfig{config.Tools, config.Backends} {
+		for _, toolConfig := range tools {
+			tmplStrs = append(tmplStrs, toolConfig.Template)
+		}
+	}
+
+	ok = true
+	for _, tmplStr := range tmplStrs {
+		tmpl, err := parseConfigTemplate(config, tmplStr)
+		if err != nil {
+			continue
+		}
+		for _, t := range tmpl.Templates() {
+			if t.Tree == nil {
+				continue
+			}
+			walkEnvCalls(t.Tree.Root, func(name string, constant bool) {
+				if !constant {
+					ok = false
+				} else if !containsString(vars, name) {
+					vars = append(vars, name)
+				}
+			})
+		}
+	}
+	sort.Strings(vars)
+	return vars, ok
+}
+
+func walkEnvCalls(node parse.Node, visit func(name string, constant bool)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkEnvCalls(child, visit)
+		}
+	case *parse.ActionNode:
+		walkEnvPipe(n.Pipe, visit)
+	case *parse.IfNode:
+		walkEnvPipe(n.Pipe, visit)
+		walkEnvCalls(n.List, visit)
+		walkEnvCalls(n.ElseList, visit)
+	case *parse.RangeNode:
+		walkEnvPipe(n.Pipe, visit)
+		walkEnvCalls(n.List, visit)
+		walkEnvCalls(n.ElseList, visit)
+	case *parse.WithNode:
+		walkEnvPipe(n.Pipe, visit)
+		walkEnvCalls(n.List, visit)
+		walkEnvCalls(n.ElseList, visit)
+	case *parse.TemplateNode:
+		walkEnvPipe(n.Pipe, visit)
+	}
+}
+
+// walkEnvPipe finds env calls in a pipeline, either as env "NAME" or as
+// "NAME" | env. Any other use of env counts as a dynamic name.
+func walkEnvPipe(pipe *parse.PipeNode, visit func(name string, constant bool)) {
+	if pipe == nil {
+		return
+	}
+	for i, cmd := range pipe.Cmds {
+		for j, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.PipeNode:
+				walkEnvPipe(a, visit)
+			case *parse.IdentifierNode:
+				if a.Ident != "env" {
+					continue
+				}
+				var name parse.Node
+				switch {
+				case j == 0 && len(cmd.Args) == 2:
+					name = cmd.Args[1]
+				case j == 0 && len(cmd.Args) == 1 && i > 0 && len(pipe.Cmds[i-1].Args) == 1:
+					name = pipe.Cmds[i-1].Args[0]
+				}
+				if s, ok := name.(*parse.StringNode); ok {
+					visit(s.Text, true)
+				} else {
+					visit("", false)
+				}
+			}
+		}
+	}
+}
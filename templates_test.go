@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	configDir := t.TempDir()
+	configFile := filepath.Join(configDir, "config.jsonc")
+	templatesDir := filepath.Join(configDir, "config.templates")
+	os.MkdirAll(templatesDir, 0755)
+	os.WriteFile(filepath.Join(templatesDir, "version.tmpl"), []byte("{{.ResolvedVersion | trimPrefix \"v\"}}\n"), 0644)
+	os.WriteFile(filepath.Join(templatesDir, "name.tmpl"), []byte("overridden"), 0644)
+	os.WriteFile(filepath.Join(templatesDir, "notes.txt"), []byte("{{"), 0644)
+	os.WriteFile(filepath.Join(configDir, "main.tmpl"), []byte("{{template \"name\" .}}@{{template \"version\" .}} \n"), 0644)
+
+	config := ProtoConfig{
+		Template:     "ignored",
+		TemplateFile: "main.tmpl",
+		Templates:    map[string]string{"name": "{{.Label}}"},
+	}
+	if err := loadTemplates(&config, configFile); err != nil {
+		t.Fatalf("loadTemplates() error = %v", err)
+	}
+
+	if config.Template != "{{template \"name\" .}}@{{template \"version\" .}} " {
+		t.Errorf("Template = %q, want the contents of template_file without the final newline", config.Template)
+	}
+	if got := strings.Join(partialNames(config), ","); got != "name,version" {
+		t.Errorf("partialNames() = %q, want name,version", got)
+	}
+
+	tools := map[string]ToolStatus{"node": {IsInstalled: true, ResolvedVersion: "v22.1.0"}}
+	config.Tools = map[string]IconConfig{"node": {Label: "Node"}}
+	if output := formatOutput(tools, nil, config, FetchInfo{}); output != "Node@22.1.0" {
+		t.Errorf("formatOutput() = %q, want Node@22.1.0", output)
+	}
+
+	if templateSourcesChanged(config) {
+		t.Error("templateSourcesChanged() = true before any edit")
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(templatesDir, "version.tmpl"), later, later)
+	if !templateSourcesChanged(config) {
+		t.Error("templateSourcesChanged() = false after editing a partial")
+	}
+}
+
+func TestLoadTemplatesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ProtoConfig
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "partial syntax",
+			config:  ProtoConfig{Templates: map[string]string{"broken": "{{.Tool"}},
+			wantErr: "templates.broken",
+		},
+		{
+			name:    "file syntax",
+			files:   map[string]string{"config.templates/broken.tmpl": "{{if}}"},
+			wantErr: "broken.tmpl",
+		},
+		{
+			name:    "undefined partial",
+			config:  ProtoConfig{Templates: map[string]string{"a": "{{if .IsLast}}{{template \"b\" .}}{{end}}"}},
+			wantErr: `no template named "b"`,
+		},
+		{
+			name:    "reserved partial",
+			config:  ProtoConfig{Templates: map[string]string{"tool": "{{.Tool}}"}},
+			wantErr: `templates.tool: partial name "tool" is reserved`,
+		},
+		{
+			name:    "reserved partial file",
+			files:   map[string]string{"config.templates/output.tmpl": "{{.Tool}}"},
+			wantErr: `output.tmpl: partial name "output" is reserved`,
+		},
+		{
+			name:    "missing template_file",
+			config:  ProtoConfig{TemplateFile: "missing.tmpl"},
+			wantErr: "missing.tmpl",
+		},
+		{
+			name:    "template_file calls undefined partial",
+			config:  ProtoConfig{TemplateFile: "main.tmpl"},
+			files:   map[string]string{"main.tmpl": "{{range .Tools}}{{template \"row\" .}}{{end}}"},
+			wantErr: `no template named "row"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configDir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(configDir, name)
+				os.MkdirAll(filepath.Dir(path), 0755)
+				os.WriteFile(path, []byte(content), 0644)
+			}
+
+			config := tt.config
+			err := loadTemplates(&config, filepath.Join(configDir, "config.jsonc"))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadTemplates() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadTemplatesAllowsToolPartial(t *testing.T) {
+	config := ProtoConfig{
+		Template:       "{{.Tool}}",
+		LayoutTemplate: "[{{range .Tools}}{{template \"row\" .}}{{end}}]",
+		Templates:      map[string]string{"row": "{{template \"tool\" .}};"},
+	}
+	if err := loadTemplates(&config, filepath.Join(t.TempDir(), "config.jsonc")); err != nil {
+		t.Fatalf("loadTemplates() error = %v", err)
+	}
+	if err := validateTemplate(config, config.LayoutTemplate, "tool"); err != nil {
+		t.Fatalf("validateTemplate() error = %v", err)
+	}
+
+	tools := map[string]ToolStatus{"bun": {}, "go": {}}
+	if output := formatOutput(tools, nil, config, FetchInfo{}); output != "[bun;go;]" {
+		t.Errorf("formatOutput() = %q, want [bun;go;]", output)
+	}
+}
+
+func TestLoadConfigReloadsTemplateFile(t *testing.T) {
+	oldConfigPath := configPath
+	oldCachedConfig, oldCachedConfigPath := cachedConfig, cachedConfigPath
+	defer func() {
+		configPath = oldConfigPath
+		cachedConfig, cachedConfigPath = oldCachedConfig, oldCachedConfigPath
+	}()
+
+	configDir := t.TempDir()
+	configPath = filepath.Join(configDir, "config.jsonc")
+	os.WriteFile(configPath, []byte(`{"template_file": "main.tmpl", "tools": {}}`), 0644)
+	templateFile := filepath.Join(configDir, "main.tmpl")
+	os.WriteFile(templateFile, []byte("one"), 0644)
+
+	config, err := loadConfig()
+	if err != nil || config.Template != "one" {
+		t.Fatalf("loadConfig() = %q, %v", config.Template, err)
+	}
+
+	os.WriteFile(templateFile, []byte("two!"), 0644)
+	if config, err := loadConfig(); err != nil || config.Template != "two!" {
+		t.Errorf("loadConfig() after editing template_file = %q, %v, want the new template", config.Template, err)
+	}
+}
+
+func TestTemplateEnvVars(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ProtoConfig
+		want   string
+		wantOK bool
+	}{
+		{"none", ProtoConfig{Template: "{{.Tool}}"}, "", true},
+		{"argument", ProtoConfig{Template: "{{if env \"SSH_TTY\"}}ssh{{end}}"}, "SSH_TTY", true},
+		{"pipeline", ProtoConfig{Template: "{{\"USER\" | env}}"}, "USER", true},
+		{"nested", ProtoConfig{Template: "{{printf \"%s\" (env \"TERM\")}}"}, "TERM", true},
+		{"layout, tools and partials", ProtoConfig{
+			LayoutTemplate: "{{env \"B\"}}{{range .Tools}}{{template \"tool\" .}}{{end}}",
+			Tools:          map[string]IconConfig{"node": {Template: "{{template \"row\" .}}"}},
+			Templates:      map[string]string{"row": "{{with env \"A\"}}{{.}}{{end}}{{env \"B\"}}"},
+		}, "A,B", true},
+		{"computed name", ProtoConfig{Template: "{{env .Tool}}"}, "", false},
+		{"computed pipeline", ProtoConfig{Template: "{{.Tool | env}}"}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			if err := loadTemplates(&config, filepath.Join(t.TempDir(), "config.jsonc")); err != nil {
+				t.Fatalf("loadTemplates() error = %v", err)
+			}
+			vars, ok := templateEnvVars(config)
+			if got := strings.Join(vars, ","); got != tt.want || ok != tt.wantOK {
+				t.Errorf("templateEnvVars() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// stageTimer records how long each stage of a prompt took, for --timings.
+type stageTimer struct {
+	start  time.Time
+	last   time.Time
+	stages []stageTiming
+}
+
+type stageTiming struct {
+	name     string
+	duration time.Duration
+}
+
+var promptTimings = &stageTimer{}
+
+func (t *stageTimer) begin() {
+	t.start = time.Now()
+	t.last = t.start
+	t.stages = nil
+}
+
+// mark ends the current stage. It does nothing unless begin was called.
+func (t *stageTimer) mark(name string) {
+	if t.start.IsZero() {
+		return
+	}
+	now := time.Now()
+	t.stages = append(t.stages, stageTiming{name, now.Sub(t.last)})
+	t.last = now
+}
+
+func (t *stageTimer) write(w io.Writer) {
+	for _, stage := range t.stages {
+		fmt.Fprintf(w, "%-16s %8.3fms\n", stage.name, float64(stage.duration.Microseconds())/1000)
+	}
+	fmt.Fprintf(w, "%-16s %8.3fms\n", "total", float64(t.last.Sub(t.start).Microseconds())/1000)
+}
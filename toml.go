@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML decodes the subset of TOML used by .prototools files: tables,
+// arrays of tables, dotted and quoted keys, strings, booleans, numbers,
+// arrays and inline tables. Dates are kept as their raw text. It returns
+// the root table and the order of the key/value pairs that precede the
+// first table header.
+func parseTOML(data []byte) (map[string]any, []string, error) {
+	p := &tomlParser{src: string(data), line: 1}
+	root := make(map[string]any)
+	var order []string
+	current, atRoot := root, true
+
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return root, order, nil
+		}
+
+		if p.peek() == '[' {
+			table, err := p.parseHeader(root)
+			if err != nil {
+				return nil, nil, err
+			}
+			current, atRoot = table, false
+		} else {
+			key, err := p.parseKey()
+			if err != nil {
+				return nil, nil, err
+			}
+			p.skipSpace(false)
+			if !p.consume('=') {
+				return nil, nil, p.errorf("expected '=' after key %q", strings.Join(key, "."))
+			}
+			p.skipSpace(false)
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, nil, err
+			}
+			if atRoot {
+				if _, exists := root[key[0]]; !exists {
+					order = append(order, key[0])
+				}
+			}
+			if err := p.assign(current, key, value); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		p.skipSpace(false)
+		if !p.eof() && !p.consume('\n') {
+			return nil, nil, p.errorf("unexpected %q after value", p.peek())
+		}
+		if !p.eof() {
+			p.line++
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) eof() bool { return p.pos >= len(p.src) }
+
+func (p *tomlParser) peek() byte { return p.src[p.pos] }
+
+func (p *tomlParser) consume(b byte) bool {
+	if !p.eof() && p.peek() == b {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips blanks and comments, and newlines too when multiline is set.
+func (p *tomlParser) skipSpace(multiline bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		case c == '\n' && multiline:
+			p.pos++
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) parseHeader(root map[string]any) (map[string]any, error) {
+	p.pos++
+	arrayOfTables := p.consume('[')
+	p.skipSpace(false)
+	key, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace(false)
+	if !p.consume(']') || (arrayOfTables && !p.consume(']')) {
+		return nil, p.errorf("unterminated table header %q", strings.Join(key, "."))
+	}
+
+	parent := root
+	for _, part := range key[:len(key)-1] {
+		parent, err = p.descend(parent, part)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	last := key[len(key)-1]
+	if arrayOfTables {
+		table := make(map[string]any)
+		existing, _ := parent[last].([]any)
+		parent[last] = append(existing, table)
+		return table, nil
+	}
+	return p.descend(parent, last)
+}
+
+// descend returns the table stored under key, creating it when missing. For
+// an array of tables it returns the most recently added element.
+func (p *tomlParser) descend(table map[string]any, key string) (map[string]any, error) {
+	switch existing := table[key].(type) {
+	case nil:
+		child := make(map[string]any)
+		table[key] = child
+		return child, nil
+	case map[string]any:
+		return existing, nil
+	case []any:
+		if len(existing) > 0 {
+			if child, ok := existing[len(existing)-1].(map[string]any); ok {
+				return child, nil
+			}
+		}
+	}
+	return nil, p.errorf("key %q is not a table", key)
+}
+
+func (p *tomlParser) assign(table map[string]any, key []string, value any) error {
+	var err error
+	for _, part := range key[:len(key)-1] {
+		table, err = p.descend(table, part)
+		if err != nil {
+			return err
+		}
+	}
+	last := key[len(key)-1]
+	if _, exists := table[last]; exists {
+		return p.errorf("duplicate key %q", strings.Join(key, "."))
+	}
+	table[last] = value
+	return nil
+}
+
+func (p *tomlParser) parseKey() ([]string, error) {
+	var parts []string
+	for {
+		p.skipSpace(false)
+		if p.eof() {
+			return nil, p.errorf("expected key")
+		}
+
+		var part string
+		switch p.peek() {
+		case '"':
+			s, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		case '\'':
+			s, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("invalid key character %q", p.peek())
+			}
+			part = p.src[start:p.pos]
+		}
+		parts = append(parts, part)
+
+		p.skipSpace(false)
+		if !p.consume('.') {
+			return parts, nil
+		}
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (any, error) {
+	if p.eof() {
+		return nil, p.errorf("expected value")
+	}
+
+	switch c := p.peek(); c {
+	case '"':
+		return p.parseBasicString()
+	case '\'':
+		return p.parseLiteralString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	}
+
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\r\n,]}#", p.peek()) < 0 {
+		p.pos++
+	}
+	token := p.src[start:p.pos]
+	switch {
+	case token == "":
+		return nil, p.errorf("expected value")
+	case token == "true":
+		return true, nil
+	case token == "false":
+		return false, nil
+	}
+
+	clean := strings.ReplaceAll(token, "_", "")
+	if n, err := strconv.ParseInt(clean, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(clean, 64); err == nil {
+		return f, nil
+	}
+	if token[0] >= '0' && token[0] <= '9' {
+		// Offset and local dates/times are not needed by proto's config.
+		return token, nil
+	}
+	return nil, p.errorf("invalid value %q", token)
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	multiline := strings.HasPrefix(p.src[p.pos:], `"""`)
+	if multiline {
+		p.pos += 3
+		p.trimLeadingNewline()
+	} else {
+		p.pos++
+	}
+
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		switch {
+		case multiline && strings.HasPrefix(p.src[p.pos:], `"""`):
+			p.pos += 3
+			return b.String(), nil
+		case !multiline && c == '"':
+			p.pos++
+			return b.String(), nil
+		case c == '\n' && !multiline:
+			return "", p.errorf("newline in string")
+		case c == '\\':
+			if err := p.parseEscape(&b, multiline); err != nil {
+				return "", err
+			}
+		default:
+			if c == '\n' {
+				p.line++
+			}
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *tomlParser) parseEscape(b *strings.Builder, multiline bool) error {
+	p.pos++
+	if p.eof() {
+		return p.errorf("unterminated escape")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"':
+		b.WriteByte('"')
+	case '\\':
+		b.WriteByte('\\')
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.src) {
+			return p.errorf("short unicode escape")
+		}
+		code, err := strconv.ParseUint(p.src[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return p.errorf("invalid unicode escape")
+		}
+		b.WriteRune(rune(code))
+		p.pos += size
+	case ' ', '\t', '\r', '\n':
+		if !multiline {
+			return p.errorf("invalid escape")
+		}
+		// Line ending backslash: trim the newline and leading whitespace.
+		p.pos--
+		for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+			if p.peek() == '\n' {
+				p.line++
+			}
+			p.pos++
+		}
+	default:
+		return p.errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	if strings.HasPrefix(p.src[p.pos:], "'''") {
+		p.pos += 3
+		p.trimLeadingNewline()
+		end := strings.Index(p.src[p.pos:], "'''")
+		if end < 0 {
+			return "", p.errorf("unterminated string")
+		}
+		s := p.src[p.pos : p.pos+end]
+		p.line += strings.Count(s, "\n")
+		p.pos += end + 3
+		return s, nil
+	}
+
+	p.pos++
+	end := strings.IndexAny(p.src[p.pos:], "'\n")
+	if end < 0 || p.src[p.pos+end] != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+func (p *tomlParser) trimLeadingNewline() {
+	if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+		p.pos += 2
+		p.line++
+	} else if p.consume('\n') {
+		p.line++
+	}
+}
+
+func (p *tomlParser) parseArray() ([]any, error) {
+	p.pos++
+	values := []any{}
+	for {
+		p.skipSpace(true)
+		if p.consume(']') {
+			return values, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipSpace(true)
+		if p.consume(']') {
+			return values, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (map[string]any, error) {
+	p.pos++
+	table := make(map[string]any)
+	p.skipSpace(false)
+	if p.consume('}') {
+		return table, nil
+	}
+	for {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if !p.consume('=') {
+			return nil, p.errorf("expected '=' after key %q", strings.Join(key, "."))
+		}
+		p.skipSpace(false)
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.assign(table, key, value); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace(false)
+		if p.consume('}') {
+			return table, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected ',' or '}' in inline table")
+		}
+		p.skipSpace(false)
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -186,6 +188,10 @@ func TestWriteCache(t *testing.T) {
 			if string(content)[0] != '{' || string(content)[1] != '\n' {
 				t.Error("Cache file should start with formatted JSON object")
 			}
+
+			if files, _ := os.ReadDir(tempDir); len(files) != 1 {
+				t.Errorf("writeCache() left %d files behind, want only the cache", len(files))
+			}
 		})
 	}
 }
@@ -270,7 +276,7 @@ func TestUpdateStatusCache(t *testing.T) {
 				}()
 			}
 
-			updateCache(tt.data, nil, "upwards")
+			updateCache(tt.data, nil, ProtoConfig{ConfigMode: "upwards"})
 
 			if !tt.wantPanic {
 				readData, err := os.ReadFile(cacheFile)
@@ -323,7 +329,7 @@ func TestUpdateOutdatedCache(t *testing.T) {
 				}()
 			}
 
-			updateCache(nil, tt.data, "upwards")
+			updateCache(nil, tt.data, ProtoConfig{ConfigMode: "upwards"})
 
 			if !tt.wantPanic {
 				readData, err := os.ReadFile(cacheFile)
@@ -385,6 +391,31 @@ func TestHexToANSI256(t *testing.T) {
 			hex:  "61AFEF",
 			want: "74",
 		},
+		{
+			name: "short form",
+			hex:  "#fff",
+			want: "231",
+		},
+		{
+			name: "short form color",
+			hex:  "#f00",
+			want: "196",
+		},
+		{
+			name: "too short",
+			hex:  "#ff",
+			want: "",
+		},
+		{
+			name: "not hex",
+			hex:  "#zzzzzz",
+			want: "",
+		},
+		{
+			name: "empty",
+			hex:  "#",
+			want: "",
+		},
 	}
 
 	for _, tt := range tests {
@@ -852,7 +883,7 @@ func TestLoadJSONConfig(t *testing.T) {
 }
 
 func TestProtoInstalled(t *testing.T) {
-	installed := protoInstalled()
+	installed := protoInstalled(ProtoConfig{})
 	if !installed {
 		t.Log("Proto not installed in test environment")
 	}
@@ -894,14 +925,14 @@ func TestRunProtoCommand(t *testing.T) {
 			oldRunProtoCommand := runProtoCommand
 			defer func() { runProtoCommand = oldRunProtoCommand }()
 
-			runProtoCommand = func(args []string) ([]byte, error) {
-				if len(args) != len(tt.args) {
-					t.Errorf("got %d args, want %d", len(args), len(tt.args))
+			runProtoCommand = func(ctx context.Context, proto ProtoExecConfig) ([]byte, error) {
+				if len(proto.Args) != len(tt.args) {
+					t.Errorf("got %d args, want %d", len(proto.Args), len(tt.args))
 				}
 				return tt.mockOutput, tt.mockError
 			}
 
-			output, err := runProtoCommand(tt.args)
+			output, err := runProtoCommand(context.Background(), ProtoExecConfig{Path: "proto", Args: tt.args})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("runProtoCommand() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -916,11 +947,16 @@ func TestRunProtoCommand(t *testing.T) {
 
 func TestGetProtoStatus_ProtoNotInstalled(t *testing.T) {
 	oldProtoInstalled := protoInstalled
-	defer func() { protoInstalled = oldProtoInstalled }()
+	oldLoadConfig := loadConfig
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+	}()
 
-	protoInstalled = func() bool { return false }
+	protoInstalled = func(config ProtoConfig) bool { return false }
+	loadConfig = func() (ProtoConfig, error) { return ProtoConfig{}, nil }
 
-	output := getProtoStatus()
+	output := getProtoStatus(context.Background())
 
 	if output != "" {
 		t.Errorf("getProtoStatus() = %q, want empty", output)
@@ -935,12 +971,12 @@ func TestGetProtoStatus_ConfigError(t *testing.T) {
 		loadConfig = oldLoadConfig
 	}()
 
-	protoInstalled = func() bool { return true }
+	protoInstalled = func(config ProtoConfig) bool { return true }
 	loadConfig = func() (ProtoConfig, error) {
 		return ProtoConfig{}, fmt.Errorf("config error")
 	}
 
-	output := getProtoStatus()
+	output := getProtoStatus(context.Background())
 
 	if output != "" {
 		t.Errorf("getProtoStatus() = %q, want empty", output)
@@ -951,13 +987,21 @@ func TestGetProtoStatus_ToolStatusError(t *testing.T) {
 	oldProtoInstalled := protoInstalled
 	oldLoadConfig := loadConfig
 	oldGetToolStatus := getToolStatus
+	oldGetCacheFile := getCacheFile
+	oldGetLogFile := getLogFile
 	defer func() {
 		protoInstalled = oldProtoInstalled
 		loadConfig = oldLoadConfig
 		getToolStatus = oldGetToolStatus
+		getCacheFile = oldGetCacheFile
+		getLogFile = oldGetLogFile
 	}()
 
-	protoInstalled = func() bool { return true }
+	tempDir := t.TempDir()
+	getCacheFile = func() string { return filepath.Join(tempDir, "cache.json") }
+	getLogFile = func() string { return filepath.Join(tempDir, "config.log") }
+
+	protoInstalled = func(config ProtoConfig) bool { return true }
 	loadConfig = func() (ProtoConfig, error) {
 		return ProtoConfig{
 			Tools:    map[string]IconConfig{},
@@ -965,11 +1009,11 @@ func TestGetProtoStatus_ToolStatusError(t *testing.T) {
 			Cache:    CacheConfig{TTL: 300},
 		}, nil
 	}
-	getToolStatus = func(config ProtoConfig) (map[string]ToolStatus, error) {
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
 		return nil, fmt.Errorf("status error")
 	}
 
-	output := getProtoStatus()
+	output := getProtoStatus(context.Background())
 
 	if output != "" {
 		t.Errorf("getProtoStatus() = %q, want empty", output)
@@ -990,7 +1034,7 @@ func TestGetProtoStatus_EmptyToolList(t *testing.T) {
 		formatOutput = oldFormatOutput
 	}()
 
-	protoInstalled = func() bool { return true }
+	protoInstalled = func(config ProtoConfig) bool { return true }
 	loadConfig = func() (ProtoConfig, error) {
 		return ProtoConfig{
 			Tools:    map[string]IconConfig{},
@@ -998,19 +1042,1280 @@ func TestGetProtoStatus_EmptyToolList(t *testing.T) {
 			Cache:    CacheConfig{TTL: 300},
 		}, nil
 	}
-	getToolStatus = func(config ProtoConfig) (map[string]ToolStatus, error) {
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
 		return map[string]ToolStatus{}, nil
 	}
-	getOutdatedStatus = func(config ProtoConfig) map[string]OutdatedStatus {
-		return map[string]OutdatedStatus{}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		return map[string]OutdatedStatus{}, nil
 	}
-	formatOutput = func(tools map[string]ToolStatus, outdatedTools map[string]OutdatedStatus, config ProtoConfig) string {
+	formatOutput = func(tools map[string]ToolStatus, outdatedTools map[string]OutdatedStatus, config ProtoConfig, info FetchInfo) string {
 		return "empty"
 	}
 
-	output := getProtoStatus()
+	output := getProtoStatus(context.Background())
 
 	if output != "empty" {
 		t.Errorf("getProtoStatus() = %q, want empty", output)
 	}
 }
+
+func TestGetCommandTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ProtoConfig
+		want   time.Duration
+	}{
+		{"default", ProtoConfig{}, defaultCommandTimeoutMs * time.Millisecond},
+		{"negative falls back to default", ProtoConfig{Timeout: TimeoutConfig{CommandMs: -1}}, defaultCommandTimeoutMs * time.Millisecond},
+		{"custom", ProtoConfig{Timeout: TimeoutConfig{CommandMs: 250}}, 250 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getCommandTimeout(tt.config); got != tt.want {
+				t.Errorf("getCommandTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTotalTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ProtoConfig
+		want   time.Duration
+	}{
+		{"default", ProtoConfig{}, defaultTotalTimeoutMs * time.Millisecond},
+		{"custom", ProtoConfig{Timeout: TimeoutConfig{TotalMs: 800}}, 800 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getTotalTimeout(tt.config); got != tt.want {
+				t.Errorf("getTotalTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunProtoAppliesCommandTimeout(t *testing.T) {
+	oldRunProtoCommand := runProtoCommand
+	defer func() { runProtoCommand = oldRunProtoCommand }()
+
+	runProtoCommand = func(ctx context.Context, proto ProtoExecConfig) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	start := time.Now()
+	_, err := runProto(context.Background(), ProtoConfig{Timeout: TimeoutConfig{CommandMs: 20}}, []string{"status"})
+	if err == nil {
+		t.Fatal("runProto() expected deadline error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("runProto() took %v, want it bounded by the command timeout", elapsed)
+	}
+}
+
+func TestGetProtoStatus_TimeoutFallsBackToStaleCache(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldGetCacheFile := getCacheFile
+	oldGetDirectoryContext := getDirectoryContext
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		getCacheFile = oldGetCacheFile
+		getDirectoryContext = oldGetDirectoryContext
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	stale := CachedData{
+		Entries: map[string]DirectoryCacheData{
+			"test-hash": {
+				StatusData: map[string]ToolStatus{"node": {ResolvedVersion: "22.0.0", IsInstalled: true}},
+				Timestamp:  time.Now().Add(-time.Hour).Unix(),
+			},
+		},
+	}
+	jsonData, _ := json.Marshal(stale)
+	os.WriteFile(cacheFile, jsonData, 0644)
+
+	getCacheFile = func() string { return cacheFile }
+	getDirectoryContext = func(config ProtoConfig) (string, error) { return "test-hash", nil }
+	protoInstalled = func(config ProtoConfig) bool { return true }
+	loadConfig = func() (ProtoConfig, error) {
+		return ProtoConfig{
+			Template: "{{.Tool}} {{.ResolvedVersion}}",
+			Timeout:  TimeoutConfig{TotalMs: 20},
+		}, nil
+	}
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		<-ctx.Done()
+		return nil, nil
+	}
+
+	output := getProtoStatus(context.Background())
+
+	if output != "node 22.0.0" {
+		t.Errorf("getProtoStatus() = %q, want stale cache output", output)
+	}
+}
+
+func TestGetProtoStatus_TimeoutRendersStatusOnly(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		getCacheFile = oldGetCacheFile
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	getCacheFile = func() string { return cacheFile }
+	protoInstalled = func(config ProtoConfig) bool { return true }
+	loadConfig = func() (ProtoConfig, error) {
+		return ProtoConfig{
+			Template: "{{.Tool}} {{.ResolvedVersion}}",
+			Timeout:  TimeoutConfig{TotalMs: 20},
+		}, nil
+	}
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		return map[string]ToolStatus{"go": {ResolvedVersion: "1.26.0", IsInstalled: true}}, nil
+	}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		<-ctx.Done()
+		return nil, nil
+	}
+
+	output := getProtoStatus(context.Background())
+
+	if output != "go 1.26.0" {
+		t.Errorf("getProtoStatus() = %q, want status-only output", output)
+	}
+	if entry, ok := lookupCacheEntry(ProtoConfig{}); ok && entry.StatusData != nil {
+		t.Error("Partial results should not be written to the cache")
+	}
+}
+
+func TestGetProtoStatus_OutdatedErrorKeepsCache(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldGetCacheFile := getCacheFile
+	oldGetDirectoryContext := getDirectoryContext
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		getCacheFile = oldGetCacheFile
+		getDirectoryContext = oldGetDirectoryContext
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	timestamp := time.Now().Add(-time.Hour).Unix()
+	expired := CachedData{
+		Entries: map[string]DirectoryCacheData{
+			"test-hash": {
+				StatusData:   map[string]ToolStatus{"node": {ResolvedVersion: "22.0.0", IsInstalled: true}},
+				OutdatedData: map[string]OutdatedStatus{"node": {IsOutdated: true, NewestVersion: "22.1.0"}},
+				Timestamp:    timestamp,
+			},
+		},
+	}
+	jsonData, _ := json.Marshal(expired)
+	os.WriteFile(cacheFile, jsonData, 0644)
+
+	getCacheFile = func() string { return cacheFile }
+	getDirectoryContext = func(config ProtoConfig) (string, error) { return "test-hash", nil }
+	protoInstalled = func(config ProtoConfig) bool { return true }
+	loadConfig = func() (ProtoConfig, error) {
+		return ProtoConfig{Template: "{{.Tool}} {{.ResolvedVersion}}"}, nil
+	}
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		return map[string]ToolStatus{"node": {ResolvedVersion: "22.0.0", IsInstalled: true}}, nil
+	}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		return map[string]OutdatedStatus{}, newProtoError([]string{"outdated", "--json"}, fmt.Errorf("registry unavailable"), "", 0)
+	}
+
+	getProtoStatus(context.Background())
+
+	entry, ok := lookupCacheEntry(ProtoConfig{})
+	if !ok {
+		t.Fatal("The cache entry should be kept")
+	}
+	if entry.OutdatedData["node"].NewestVersion != "22.1.0" || entry.Timestamp != timestamp {
+		t.Errorf("A failed outdated query replaced the last good entry: %+v", entry)
+	}
+	if len(entry.Errors) != 1 {
+		t.Errorf("entry.Errors = %v, want the outdated failure", entry.Errors)
+	}
+}
+
+func TestGetProtoStatus_PromptBudgetDefersOutdated(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldGetCacheFile := getCacheFile
+	oldGetDirectoryContext := getDirectoryContext
+	oldStartBackgroundRefresh := startBackgroundRefresh
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		getCacheFile = oldGetCacheFile
+		getDirectoryContext = oldGetDirectoryContext
+		startBackgroundRefresh = oldStartBackgroundRefresh
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	getCacheFile = func() string { return cacheFile }
+	getDirectoryContext = func(config ProtoConfig) (string, error) { return "test-hash", nil }
+	protoInstalled = func(config ProtoConfig) bool { return true }
+	loadConfig = func() (ProtoConfig, error) {
+		return ProtoConfig{
+			Template:       "{{.Tool}} {{.ResolvedVersion}} {{.OutdatedPending}}",
+			PromptBudgetMs: 10,
+		}, nil
+	}
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		return map[string]ToolStatus{"node": {ResolvedVersion: "24.0.0", IsInstalled: true}}, nil
+	}
+	outdatedCancelled := false
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		<-ctx.Done()
+		outdatedCancelled = true
+		return nil, ctx.Err()
+	}
+	refreshes := 0
+	startBackgroundRefresh = func() error {
+		refreshes++
+		return nil
+	}
+
+	output := getProtoStatus(context.Background())
+
+	if output != "node 24.0.0 true" {
+		t.Errorf("getProtoStatus() = %q, want status-only output marked pending", output)
+	}
+	if !outdatedCancelled {
+		t.Error("The outdated query should be cancelled and drained before returning")
+	}
+	if refreshes != 1 {
+		t.Errorf("startBackgroundRefresh() called %d times, want 1", refreshes)
+	}
+	if _, ok := lookupCacheEntry(ProtoConfig{}); ok {
+		t.Error("Status-only data of a spent budget should not be cached")
+	}
+}
+
+func TestGetProtoStatus_PromptBudgetIgnoredWhenSilent(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldGetCacheFile := getCacheFile
+	oldStartBackgroundRefresh := startBackgroundRefresh
+	oldSilentMode := silentMode
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		getCacheFile = oldGetCacheFile
+		startBackgroundRefresh = oldStartBackgroundRefresh
+		silentMode = oldSilentMode
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	getCacheFile = func() string { return cacheFile }
+	protoInstalled = func(config ProtoConfig) bool { return true }
+	loadConfig = func() (ProtoConfig, error) {
+		return ProtoConfig{
+			Template:       "{{.NewestVersion}} {{.OutdatedPending}}",
+			PromptBudgetMs: 1,
+		}, nil
+	}
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		return map[string]ToolStatus{"node": {ResolvedVersion: "24.0.0", IsInstalled: true}}, nil
+	}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		time.Sleep(20 * time.Millisecond)
+		return map[string]OutdatedStatus{"node": {IsOutdated: true, NewestVersion: "24.1.0"}}, nil
+	}
+	startBackgroundRefresh = func() error {
+		t.Error("A --silent refresh must not start another refresh")
+		return nil
+	}
+	silentMode = true
+
+	if output := getProtoStatus(context.Background()); output != "24.1.0 false" {
+		t.Errorf("getProtoStatus() = %q, want full output", output)
+	}
+}
+
+func TestRefreshCommand(t *testing.T) {
+	oldConfigPath := configPath
+	defer func() { configPath = oldConfigPath }()
+	configPath = "/tmp/work.jsonc"
+
+	cmd, err := refreshCommand()
+	if err != nil {
+		t.Fatalf("refreshCommand() error = %v", err)
+	}
+	if !reflect.DeepEqual(cmd.Args[1:], []string{"--refresh", "--silent", "--config", "/tmp/work.jsonc"}) {
+		t.Errorf("refreshCommand() args = %v", cmd.Args)
+	}
+	if cmd.Stdin != nil || cmd.Stdout != nil || cmd.Stderr != nil {
+		t.Error("The refresher must not inherit stdio")
+	}
+	if cmd.SysProcAttr == nil {
+		t.Error("The refresher must be detached")
+	}
+}
+
+func TestAcquireRefreshLock(t *testing.T) {
+	oldGetCacheFile := getCacheFile
+	defer func() { getCacheFile = oldGetCacheFile }()
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	getCacheFile = func() string { return cacheFile }
+
+	config := ProtoConfig{}
+	release, ok := acquireRefreshLock(config)
+	if !ok {
+		t.Fatal("acquireRefreshLock() failed without a running refresher")
+	}
+	if !refreshRunning(config) {
+		t.Error("refreshRunning() = false while the lock is held")
+	}
+	if _, ok := acquireRefreshLock(config); ok {
+		t.Error("acquireRefreshLock() succeeded while another refresher holds the lock")
+	}
+
+	release()
+	if refreshRunning(config) {
+		t.Error("refreshRunning() = true after release")
+	}
+
+	// A refresher that died leaves its lock behind.
+	os.WriteFile(getRefreshLockFile(), []byte("1\n"), 0644)
+	stale := time.Now().Add(-2 * getTotalTimeout(config))
+	os.Chtimes(getRefreshLockFile(), stale, stale)
+	if refreshRunning(config) {
+		t.Error("refreshRunning() = true for a stale lock")
+	}
+	release, ok = acquireRefreshLock(config)
+	if !ok {
+		t.Fatal("acquireRefreshLock() should replace a stale lock")
+	}
+	release()
+}
+
+func TestGetProtoStatus_RefreshSkippedWhileRunning(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldGetCacheFile := getCacheFile
+	oldStartBackgroundRefresh := startBackgroundRefresh
+	oldSilentMode := silentMode
+	oldForceRefresh := forceRefresh
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		getCacheFile = oldGetCacheFile
+		startBackgroundRefresh = oldStartBackgroundRefresh
+		silentMode = oldSilentMode
+		forceRefresh = oldForceRefresh
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	getCacheFile = func() string { return cacheFile }
+	protoInstalled = func(config ProtoConfig) bool { return true }
+	loadConfig = func() (ProtoConfig, error) {
+		return ProtoConfig{Template: "{{.Tool}}", PromptBudgetMs: 1}, nil
+	}
+	queries := 0
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		queries++
+		return map[string]ToolStatus{"node": {ResolvedVersion: "24.0.0", IsInstalled: true}}, nil
+	}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	startBackgroundRefresh = func() error {
+		t.Error("startBackgroundRefresh() called while a refresher is running")
+		return nil
+	}
+
+	release, _ := acquireRefreshLock(ProtoConfig{})
+	defer release()
+
+	if output := getProtoStatus(context.Background()); output != "node" {
+		t.Errorf("getProtoStatus() = %q, want status-only output", output)
+	}
+
+	// A second refresher exits without querying proto.
+	silentMode, forceRefresh = true, true
+	queries = 0
+	if output := getProtoStatus(context.Background()); output != "" || queries != 0 {
+		t.Errorf("getProtoStatus() = %q after %d queries, want a no-op", output, queries)
+	}
+}
+
+func TestGetProtoStatus_PromptBudgetNotSpent(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		getCacheFile = oldGetCacheFile
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	getCacheFile = func() string { return cacheFile }
+	protoInstalled = func(config ProtoConfig) bool { return true }
+	loadConfig = func() (ProtoConfig, error) {
+		return ProtoConfig{
+			Template:       "{{.NewestVersion}} {{.OutdatedPending}}",
+			PromptBudgetMs: 1000,
+		}, nil
+	}
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		return map[string]ToolStatus{"node": {ResolvedVersion: "24.0.0", IsInstalled: true}}, nil
+	}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		return map[string]OutdatedStatus{"node": {IsOutdated: true, NewestVersion: "24.1.0"}}, nil
+	}
+
+	output := getProtoStatus(context.Background())
+
+	if output != "24.1.0 false" {
+		t.Errorf("getProtoStatus() = %q, want full output", output)
+	}
+}
+
+func TestIsOffline(t *testing.T) {
+	tests := []struct {
+		name   string
+		flag   bool
+		config ProtoConfig
+		env    string
+		want   bool
+	}{
+		{"online by default", false, ProtoConfig{}, "", false},
+		{"flag", true, ProtoConfig{}, "", true},
+		{"config", false, ProtoConfig{Offline: true}, "", true},
+		{"PROTO_OFFLINE=1", false, ProtoConfig{}, "1", true},
+		{"PROTO_OFFLINE=true", false, ProtoConfig{}, "true", true},
+		{"PROTO_OFFLINE=0", false, ProtoConfig{}, "0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldOfflineMode := offlineMode
+			defer func() { offlineMode = oldOfflineMode }()
+
+			offlineMode = tt.flag
+			t.Setenv("PROTO_OFFLINE", tt.env)
+
+			if got := isOffline(tt.config); got != tt.want {
+				t.Errorf("isOffline() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatOutputOutdatedUnknown(t *testing.T) {
+	config := ProtoConfig{
+		Template: "{{.Tool}}:{{.OutdatedUnknown}}:{{.NewestVersion}}:{{.LatestVersion}} ",
+	}
+
+	tools := map[string]ToolStatus{
+		"go":   {ResolvedVersion: "1.26.0", IsInstalled: true},
+		"node": {ResolvedVersion: "24.0.0", IsInstalled: true},
+	}
+
+	outdated := map[string]OutdatedStatus{
+		"node": {IsOutdated: true, NewestVersion: "24.1.0", LatestVersion: "25.0.0"},
+	}
+
+	output := formatOutput(tools, outdated, config, FetchInfo{})
+	if output != "go:true:: node:false:24.1.0:25.0.0" {
+		t.Errorf("formatOutput() = %q", output)
+	}
+
+	output = formatOutput(tools, outdated, config, FetchInfo{OutdatedUnknown: true})
+	if output != "go:true:: node:true::" {
+		t.Errorf("formatOutput() offline = %q", output)
+	}
+}
+
+func TestDefaultTemplateOutdatedUnknown(t *testing.T) {
+	tools := map[string]ToolStatus{
+		"node": {ResolvedVersion: "24.0.0", IsInstalled: true},
+	}
+
+	output := formatOutput(tools, nil, ProtoConfig{}, FetchInfo{OutdatedUnknown: true})
+
+	if !contains(output, "24.0.0") || !contains(output, "?") {
+		t.Errorf("Default template should mark unknown outdated state, got %q", output)
+	}
+	if contains(output, formatColor("green", true)) {
+		t.Errorf("Default template should not claim the tool is latest, got %q", output)
+	}
+}
+
+func TestGetProtoStatus_OfflineSkipsOutdated(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		getCacheFile = oldGetCacheFile
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	getCacheFile = func() string { return cacheFile }
+	protoInstalled = func(config ProtoConfig) bool { return true }
+	loadConfig = func() (ProtoConfig, error) {
+		return ProtoConfig{
+			Template: "{{.Tool}} {{.OutdatedUnknown}}",
+			Offline:  true,
+		}, nil
+	}
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		return map[string]ToolStatus{"node": {ResolvedVersion: "24.0.0", IsInstalled: true}}, nil
+	}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		t.Error("getOutdatedStatus should not run offline")
+		return nil, nil
+	}
+
+	output := getProtoStatus(context.Background())
+
+	if output != "node true" {
+		t.Errorf("getProtoStatus() = %q, want offline output", output)
+	}
+	cached, err := readCache()
+	if err != nil || len(cached.Entries) != 1 {
+		t.Fatalf("Offline results should be cached, got %v, %v", cached.Entries, err)
+	}
+	for _, entry := range cached.Entries {
+		if !entry.StatusOnly || entry.OutdatedData != nil {
+			t.Errorf("Offline cache entry = %+v, want status only", entry)
+		}
+	}
+}
+
+func TestGetProtoStatus_OfflineCache(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldGetCacheFile := getCacheFile
+	oldGetDirectoryContext := getDirectoryContext
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		getCacheFile = oldGetCacheFile
+		getDirectoryContext = oldGetDirectoryContext
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	getCacheFile = func() string { return cacheFile }
+	getDirectoryContext = func(config ProtoConfig) (string, error) { return "test-hash", nil }
+	protoInstalled = func(config ProtoConfig) bool { return true }
+
+	offline := true
+	loadConfig = func() (ProtoConfig, error) {
+		return ProtoConfig{
+			Template: "{{.Tool}} {{.OutdatedUnknown}} {{.IsOutdated}}",
+			Offline:  offline,
+		}, nil
+	}
+	statusCalls, outdatedCalls := 0, 0
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		statusCalls++
+		return map[string]ToolStatus{"node": {ResolvedVersion: "24.0.0", IsInstalled: true}}, nil
+	}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		outdatedCalls++
+		return map[string]OutdatedStatus{"node": {IsOutdated: true, NewestVersion: "24.1.0"}}, nil
+	}
+
+	// An offline entry is reused offline...
+	getProtoStatus(context.Background())
+	if output := getProtoStatus(context.Background()); output != "node true false" || statusCalls != 1 {
+		t.Errorf("getProtoStatus() offline = %q after %d status queries, want a cache hit", output, statusCalls)
+	}
+
+	// ...but online prompts fetch the outdated data it lacks.
+	offline = false
+	if output := getProtoStatus(context.Background()); output != "node false true" || outdatedCalls != 1 {
+		t.Errorf("getProtoStatus() online = %q after %d outdated queries, want a fetch", output, outdatedCalls)
+	}
+
+	// Going offline again keeps the outdated data of the online fetch.
+	offline = true
+	forceRefresh = true
+	defer func() { forceRefresh = false }()
+	if output := getProtoStatus(context.Background()); output != "node false true" || outdatedCalls != 1 {
+		t.Errorf("getProtoStatus() offline refresh = %q, want the last known outdated data", output)
+	}
+	if entry, ok := lookupCacheEntry(ProtoConfig{}); !ok || !entry.StatusOnly || entry.OutdatedData == nil {
+		t.Errorf("cache entry = %+v, want status only with the earlier outdated data", entry)
+	}
+}
+
+func TestResolveProtoPath(t *testing.T) {
+	homeDir, _ := os.UserHomeDir()
+	t.Setenv("PROTO_BIN_DIR", "/opt/proto/bin")
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"default", "", "proto"},
+		{"bare name", "proto-nightly", "proto-nightly"},
+		{"absolute", "/usr/local/bin/proto", "/usr/local/bin/proto"},
+		{"home", "~/.proto/bin/proto", filepath.Join(homeDir, ".proto/bin/proto")},
+		{"env var", "$PROTO_BIN_DIR/proto", "/opt/proto/bin/proto"},
+		{"relative to config", "bin/proto", filepath.Join("/etc/omp", "bin/proto")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldGetConfigFilePath := getConfigFilePath
+			defer func() { getConfigFilePath = oldGetConfigFilePath }()
+			getConfigFilePath = func() string { return "/etc/omp/config.jsonc" }
+
+			got := resolveProtoPath(ProtoConfig{Proto: ProtoExecConfig{Path: tt.path}})
+			if got != tt.want {
+				t.Errorf("resolveProtoPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	merged := mergeEnv([]string{"PATH=/bin", "PROTO_LOG=debug", "EMPTY="}, map[string]string{
+		"PROTO_LOG": "off",
+		"NO_COLOR":  "1",
+	})
+
+	want := map[string]bool{"PATH=/bin": true, "EMPTY=": true, "PROTO_LOG=off": true, "NO_COLOR=1": true}
+	if len(merged) != len(want) {
+		t.Fatalf("mergeEnv() = %v", merged)
+	}
+	for _, entry := range merged {
+		if !want[entry] {
+			t.Errorf("mergeEnv() unexpected entry %q", entry)
+		}
+	}
+}
+
+func TestRunProtoUsesExecConfig(t *testing.T) {
+	oldRunProtoCommand := runProtoCommand
+	oldOfflineMode := offlineMode
+	defer func() {
+		runProtoCommand = oldRunProtoCommand
+		offlineMode = oldOfflineMode
+	}()
+
+	var got ProtoExecConfig
+	runProtoCommand = func(ctx context.Context, proto ProtoExecConfig) ([]byte, error) {
+		got = proto
+		return nil, nil
+	}
+
+	config := ProtoConfig{
+		Offline: true,
+		Proto: ProtoExecConfig{
+			Path: "/opt/proto/bin/proto",
+			Args: []string{"--log", "off"},
+			Env:  map[string]string{"NO_COLOR": "1"},
+		},
+	}
+	runProto(context.Background(), config, []string{"status", "--json"})
+
+	if got.Path != "/opt/proto/bin/proto" {
+		t.Errorf("Path = %q", got.Path)
+	}
+	if strings.Join(got.Args, " ") != "--log off status --json" {
+		t.Errorf("Args = %v", got.Args)
+	}
+	if got.Env["NO_COLOR"] != "1" || got.Env["PROTO_OFFLINE"] != "1" {
+		t.Errorf("Env = %v", got.Env)
+	}
+	if len(config.Proto.Env) != 1 {
+		t.Errorf("runProto() should not modify the configured env, got %v", config.Proto.Env)
+	}
+}
+
+func TestGetProtoHome(t *testing.T) {
+	t.Setenv("PROTO_HOME", "")
+	if got := getProtoHome(ProtoConfig{}, "/home/user"); got != filepath.Join("/home/user", ".proto") {
+		t.Errorf("getProtoHome() = %q, want default", got)
+	}
+
+	t.Setenv("PROTO_HOME", "/env/proto")
+	if got := getProtoHome(ProtoConfig{}, "/home/user"); got != "/env/proto" {
+		t.Errorf("getProtoHome() = %q, want inherited PROTO_HOME", got)
+	}
+
+	config := ProtoConfig{Proto: ProtoExecConfig{Env: map[string]string{"PROTO_HOME": "/pinned/proto"}}}
+	if got := getProtoHome(config, "/home/user"); got != "/pinned/proto" {
+		t.Errorf("getProtoHome() = %q, want pinned PROTO_HOME", got)
+	}
+}
+
+func TestGetProtoStatus_ErrorTemplate(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		getCacheFile = oldGetCacheFile
+	}()
+
+	tempDir := t.TempDir()
+	getCacheFile = func() string { return filepath.Join(tempDir, "config.cache.json") }
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		return map[string]OutdatedStatus{}, nil
+	}
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		return nil, newProtoError([]string{"status", "--json"}, exitCodeError{1}, "error: invalid .prototools", 0)
+	}
+
+	errorTemplate := "{{.Stage}}|{{.Message}}|{{.Command}}|{{.ExitCode}}  "
+
+	tests := []struct {
+		name      string
+		installed bool
+		config    ProtoConfig
+		configErr error
+		expected  string
+	}{
+		{
+			name:      "config error",
+			installed: true,
+			config:    ProtoConfig{ErrorTemplate: errorTemplate},
+			configErr: fmt.Errorf("bad ttl"),
+			expected:  "config|bad ttl||0",
+		},
+		{
+			name:     "proto not installed",
+			config:   ProtoConfig{ErrorTemplate: errorTemplate},
+			expected: "proto|proto not found||0",
+		},
+		{
+			name:      "template error",
+			installed: true,
+			config:    ProtoConfig{ErrorTemplate: errorTemplate, Template: "{{.Tool"},
+			expected:  "template|template: output:1: unclosed action||0",
+		},
+		{
+			name:      "status error",
+			installed: true,
+			config:    ProtoConfig{ErrorTemplate: errorTemplate, Cache: CacheConfig{TTL: 0}},
+			expected:  "status|error: invalid .prototools|proto status --json|1",
+		},
+		{
+			name:      "no error template",
+			installed: true,
+			config:    ProtoConfig{},
+			expected:  "",
+		},
+		{
+			name:      "broken error template",
+			installed: true,
+			config:    ProtoConfig{ErrorTemplate: "{{.Missing"},
+			expected:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protoInstalled = func(config ProtoConfig) bool { return tt.installed }
+			loadConfig = func() (ProtoConfig, error) { return tt.config, tt.configErr }
+
+			output := getProtoStatus(context.Background())
+
+			if output != tt.expected {
+				t.Errorf("getProtoStatus() = %q, want %q", output, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadJSONConfigKeepsErrorTemplate(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.jsonc")
+	os.WriteFile(configFile, []byte(`{"error_template": "!", "cache": {"ttl": "soon"}}`), 0644)
+
+	config, err := loadJSONConfig(configFile)
+
+	if err == nil {
+		t.Fatal("loadJSONConfig() should fail on a mistyped value")
+	}
+	if config.ErrorTemplate != "!" {
+		t.Errorf("ErrorTemplate = %q, want it kept on a type error", config.ErrorTemplate)
+	}
+}
+
+func TestFormatOutputIsolatesToolPanics(t *testing.T) {
+	oldGetCacheFile := getCacheFile
+	oldGetDirectoryContext := getDirectoryContext
+	defer func() {
+		getCacheFile = oldGetCacheFile
+		getDirectoryContext = oldGetDirectoryContext
+	}()
+
+	tempDir := t.TempDir()
+	getCacheFile = func() string { return filepath.Join(tempDir, "config.cache.json") }
+	getDirectoryContext = func(config ProtoConfig) (string, error) { return "test-hash", nil }
+
+	tools := map[string]ToolStatus{
+		"go":   {IsInstalled: true, ResolvedVersion: "1.23.0"},
+		"node": {IsInstalled: true, ResolvedVersion: "22.1.0"},
+		"bun":  {IsInstalled: true, ResolvedVersion: "1.1.0"},
+	}
+	config := ProtoConfig{
+		Template: "{{.Tool}}={{if eq .Tool \"bun\"}}{{index .Tool 99}}{{end}}{{.ResolvedVersion}} ",
+		Tools:    map[string]IconConfig{"go": {Icon: "e627", Color: "#fff"}},
+	}
+
+	output := formatOutput(tools, nil, config, FetchInfo{})
+
+	if output != "go=1.23.0 node=22.1.0" {
+		t.Errorf("formatOutput() = %q, want bun dropped", output)
+	}
+
+	entry, _ := lookupCacheEntry(ProtoConfig{})
+	if len(entry.RenderErrors) != 1 || entry.RenderErrors[0].Tool != "bun" {
+		t.Errorf("RenderErrors = %+v, want bun", entry.RenderErrors)
+	}
+}
+
+func TestRenderToolRecoversPanic(t *testing.T) {
+	// A nil template panics on Execute.
+	segment, err := renderTool(nil, TemplateData{Tool: "go"})
+
+	if segment != "" || err == nil || err.Tool != "go" || !strings.HasPrefix(err.Message, "panic: ") {
+		t.Errorf("renderTool() = %q, %+v, want recovered panic", segment, err)
+	}
+}
+
+func TestGetProtoStatus_RecoversPanic(t *testing.T) {
+	oldProtoInstalled := protoInstalled
+	oldLoadConfig := loadConfig
+	oldGetToolStatus := getToolStatus
+	oldGetOutdatedStatus := getOutdatedStatus
+	oldFormatOutput := formatOutput
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		protoInstalled = oldProtoInstalled
+		loadConfig = oldLoadConfig
+		getToolStatus = oldGetToolStatus
+		getOutdatedStatus = oldGetOutdatedStatus
+		formatOutput = oldFormatOutput
+		getCacheFile = oldGetCacheFile
+	}()
+
+	tempDir := t.TempDir()
+	getCacheFile = func() string { return filepath.Join(tempDir, "config.cache.json") }
+	protoInstalled = func(config ProtoConfig) bool { return true }
+	loadConfig = func() (ProtoConfig, error) {
+		return ProtoConfig{ErrorTemplate: "{{.Stage}}: {{.Message}}"}, nil
+	}
+	getToolStatus = func(ctx context.Context, config ProtoConfig) (map[string]ToolStatus, error) {
+		return map[string]ToolStatus{"go": {}}, nil
+	}
+	getOutdatedStatus = func(ctx context.Context, config ProtoConfig) (map[string]OutdatedStatus, error) {
+		return map[string]OutdatedStatus{}, nil
+	}
+	formatOutput = func(tools map[string]ToolStatus, outdatedTools map[string]OutdatedStatus, config ProtoConfig, info FetchInfo) string {
+		panic("boom")
+	}
+
+	output := getProtoStatus(context.Background())
+
+	if output != "render: panic: boom" {
+		t.Errorf("getProtoStatus() = %q, want rendered error", output)
+	}
+	log, _ := os.ReadFile(getLogFile())
+	if !strings.Contains(string(log), "render: panic: boom") {
+		t.Errorf("log = %q, want the panic recorded", log)
+	}
+}
+
+func TestParseToolID(t *testing.T) {
+	tests := []struct {
+		tool    string
+		backend string
+		name    string
+	}{
+		{"node", "", "node"},
+		{"asdf:terraform", "asdf", "terraform"},
+		{"npm:prettier", "npm", "prettier"},
+		{":odd", "", ":odd"},
+		{"odd:", "", "odd:"},
+	}
+
+	for _, tt := range tests {
+		backend, name := parseToolID(tt.tool)
+		if backend != tt.backend || name != tt.name {
+			t.Errorf("parseToolID(%q) = %q, %q, want %q, %q", tt.tool, backend, name, tt.backend, tt.name)
+		}
+	}
+}
+
+func TestLookupToolConfig(t *testing.T) {
+	config := ProtoConfig{
+		Tools: map[string]IconConfig{
+			"asdf:terraform": {Icon: "exact"},
+			"prettier":       {Icon: "bare"},
+			"node":           {Icon: "node"},
+		},
+		Backends: map[string]IconConfig{
+			"asdf": {Icon: "asdf"},
+		},
+	}
+
+	tests := []struct {
+		tool string
+		icon string
+		ok   bool
+	}{
+		{"asdf:terraform", "exact", true},
+		{"npm:prettier", "bare", true},
+		{"asdf:kubectl", "asdf", true},
+		{"npm:eslint", "", false},
+		{"node", "node", true},
+		{"deno", "", false},
+	}
+
+	for _, tt := range tests {
+		iconConfig, ok := lookupToolConfig(config, tt.tool)
+		if iconConfig.Icon != tt.icon || ok != tt.ok {
+			t.Errorf("lookupToolConfig(%q) = %q, %v, want %q, %v", tt.tool, iconConfig.Icon, ok, tt.icon, tt.ok)
+		}
+	}
+}
+
+func TestFormatOutputPluginTools(t *testing.T) {
+	config := ProtoConfig{
+		Template: "{{.ToolIcon}}|{{.Backend}}|{{.ToolName}} ",
+		Tools:    map[string]IconConfig{},
+	}
+	tools := map[string]ToolStatus{
+		"asdf:terraform": {IsInstalled: true},
+		"node":           {IsInstalled: true},
+	}
+
+	output := formatOutput(tools, nil, config, FetchInfo{})
+
+	if output != "terraform|asdf|terraform node||node" {
+		t.Errorf("formatOutput() = %q", output)
+	}
+}
+
+func TestFormatOutputLayoutTemplate(t *testing.T) {
+	tools := map[string]ToolStatus{
+		"bun":  {IsInstalled: true, ResolvedVersion: "1.1.0"},
+		"go":   {IsInstalled: true, ResolvedVersion: "1.23.0"},
+		"node": {IsInstalled: false},
+	}
+	outdated := map[string]OutdatedStatus{
+		"bun": {IsOutdated: true},
+		"go":  {},
+	}
+
+	tests := []struct {
+		name     string
+		config   ProtoConfig
+		expected string
+	}{
+		{
+			name: "separators and counts",
+			config: ProtoConfig{
+				Template:       "{{.Tool}}{{if .IsInstalled}}@{{.ResolvedVersion}}{{end}}",
+				LayoutTemplate: "[{{range .Tools}}{{template \"tool\" .}}{{if not .IsLast}} | {{end}}{{end}}] {{.OutdatedCount}}/{{.MissingCount}}/{{.InstalledCount}}/{{.Count}}",
+			},
+			expected: "[bun@1.1.0 | go@1.23.0 | node] 1/1/2/3",
+		},
+		{
+			name: "index and first",
+			config: ProtoConfig{
+				LayoutTemplate: "{{range .Tools}}{{if .IsFirst}}>{{end}}{{.Index}}{{.ToolName}} {{end}}",
+			},
+			expected: ">0bun 1go 2node ",
+		},
+		{
+			name: "per-tool template sees position",
+			config: ProtoConfig{
+				Template: "{{.Tool}}{{if not .IsLast}},{{end}}",
+			},
+			expected: "bun,go,node",
+		},
+		{
+			name: "failing layout",
+			config: ProtoConfig{
+				LayoutTemplate: "{{range .Tools}}{{.Missing}}{{end}}",
+			},
+			expected: "",
+		},
+	}
+
+	oldGetCacheFile := getCacheFile
+	defer func() { getCacheFile = oldGetCacheFile }()
+	cacheFile := filepath.Join(t.TempDir(), "config.cache.json")
+	getCacheFile = func() string { return cacheFile }
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := formatOutput(tools, outdated, tt.config, FetchInfo{})
+			if output != tt.expected {
+				t.Errorf("formatOutput() = %q, want %q", output, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatOutputToolDisplayOptions(t *testing.T) {
+	oldGetCacheFile := getCacheFile
+	defer func() { getCacheFile = oldGetCacheFile }()
+	cacheFile := filepath.Join(t.TempDir(), "config.cache.json")
+	getCacheFile = func() string { return cacheFile }
+
+	tools := map[string]ToolStatus{
+		"bun":  {IsInstalled: true, ResolvedVersion: "1.1.0"},
+		"go":   {IsInstalled: true, ResolvedVersion: "1.23.0"},
+		"moon": {IsInstalled: true, ResolvedVersion: "1.30.0"},
+		"node": {IsInstalled: true, ResolvedVersion: "22.1.0"},
+		"npm":  {IsInstalled: true, ResolvedVersion: "10.0.0"},
+		"yarn": {IsInstalled: true, ResolvedVersion: "4.0.0"},
+	}
+
+	config := ProtoConfig{
+		Template: "{{.ToolIcon}}={{.ResolvedVersion}} ",
+		Tools: map[string]IconConfig{
+			"node": {Label: "Node.js", Order: 1, Template: "{{.Label}} {{.ResolvedVersion}} ({{.Index}}) "},
+			"npm":  {Order: 2, Template: "{{.Tool}} "},
+			"moon": {Hidden: true},
+			"yarn": {Template: "{{.Missing}}"},
+		},
+	}
+
+	output := formatOutput(tools, nil, config, FetchInfo{})
+
+	if output != "Node.js 22.1.0 (0) npm bun=1.1.0 go=1.23.0" {
+		t.Errorf("formatOutput() = %q", output)
+	}
+
+	entry, _ := lookupCacheEntry(ProtoConfig{})
+	if len(entry.RenderErrors) != 1 || entry.RenderErrors[0].Tool != "yarn" {
+		t.Errorf("RenderErrors = %+v, want the broken yarn template", entry.RenderErrors)
+	}
+}
+
+func TestFormatOutputPositionsSkipFailedTools(t *testing.T) {
+	oldGetCacheFile := getCacheFile
+	defer func() { getCacheFile = oldGetCacheFile }()
+	cacheFile := filepath.Join(t.TempDir(), "config.cache.json")
+	getCacheFile = func() string { return cacheFile }
+
+	tools := map[string]ToolStatus{"bun": {}, "go": {}, "node": {}, "yarn": {}}
+	broken := map[string]IconConfig{
+		"bun":  {Template: "{{.Missing}}"},
+		"yarn": {Template: "{{.Missing}}"},
+	}
+
+	tests := []struct {
+		name     string
+		config   ProtoConfig
+		expected string
+	}{
+		{"template", ProtoConfig{
+			Template: "{{if .IsFirst}}[{{end}}{{.Index}}{{.Tool}}{{if .IsLast}}]{{else}},{{end}}",
+			Tools:    broken,
+		}, "[0go,1node]"},
+		{"layout", ProtoConfig{
+			Template:       "{{.Index}}{{.Tool}}",
+			LayoutTemplate: "{{range .Tools}}{{.Output}}{{if not .IsLast}},{{end}}{{end}}",
+			Tools:          broken,
+		}, "0go,1node"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if output := formatOutput(tools, nil, tt.config, FetchInfo{}); output != tt.expected {
+				t.Errorf("formatOutput() = %q, want %q", output, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSortToolsByOrder(t *testing.T) {
+	config := ProtoConfig{Tools: map[string]IconConfig{
+		"rust": {Order: 2},
+		"go":   {Order: 1},
+		"node": {Order: 3},
+	}}
+	toolNames := []string{"bun", "deno", "go", "node", "rust"}
+
+	sortToolsByOrder(toolNames, config)
+
+	if !reflect.DeepEqual(toolNames, []string{"go", "rust", "node", "bun", "deno"}) {
+		t.Errorf("sortToolsByOrder() = %v", toolNames)
+	}
+}
+
+func TestFormatOutputDisplayRules(t *testing.T) {
+	oldGetCacheFile := getCacheFile
+	defer func() { getCacheFile = oldGetCacheFile }()
+	cacheFile := filepath.Join(t.TempDir(), "config.cache.json")
+	getCacheFile = func() string { return cacheFile }
+
+	home := t.TempDir()
+	protoHome := filepath.Join(home, ".proto")
+	project := filepath.Join(home, "project", "app")
+	os.MkdirAll(protoHome, 0755)
+	os.MkdirAll(project, 0755)
+	t.Setenv("HOME", home)
+	t.Setenv("PROTO_HOME", protoHome)
+	t.Chdir(project)
+
+	tools := map[string]ToolStatus{
+		"bun":  {IsInstalled: true, ResolvedVersion: "1.1.0", ConfigSource: filepath.Join(project, ".prototools")},
+		"go":   {IsInstalled: true, ResolvedVersion: "1.22.0", ConfigSource: filepath.Join(home, "project", ".prototools")},
+		"node": {IsInstalled: true, ResolvedVersion: "22.1.0", ConfigSource: filepath.Join(protoHome, ".prototools")},
+		"rust": {IsInstalled: false, ConfigSource: filepath.Join(project, ".prototools")},
+		"uv":   {IsInstalled: true, ResolvedVersion: "0.5.0"},
+	}
+	outdated := map[string]OutdatedStatus{
+		"bun":  {},
+		"go":   {IsOutdated: true, NewestVersion: "1.23.0", LatestVersion: "1.23.0"},
+		"node": {},
+		"uv":   {},
+	}
+
+	tests := []struct {
+		name     string
+		display  DisplayConfig
+		expected string
+	}{
+		{"no rules", DisplayConfig{}, "bun go node rust uv"},
+		{"hide when latest", DisplayConfig{HideWhen: []string{StateLatest}}, "go rust"},
+		{"only local", DisplayConfig{ShowOnlySources: []string{SourceLocal}}, "bun rust"},
+		{"local and inherited", DisplayConfig{ShowOnlySources: []string{SourceLocal, SourceInherited}}, "bun go rust"},
+		{"hide global", DisplayConfig{HideGlobal: true}, "bun go rust uv"},
+		{"combined", DisplayConfig{HideGlobal: true, HideWhen: []string{StateLatest, StateMissing}}, "go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ProtoConfig{Template: "{{.Tool}}{{if not .IsLast}} {{end}}", Display: tt.display}
+			output := formatOutput(tools, outdated, config, FetchInfo{})
+			if output != tt.expected {
+				t.Errorf("formatOutput() = %q, want %q", output, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatOutputConfigSource(t *testing.T) {
+	oldGetCacheFile := getCacheFile
+	defer func() { getCacheFile = oldGetCacheFile }()
+	cacheFile := filepath.Join(t.TempDir(), "config.cache.json")
+	getCacheFile = func() string { return cacheFile }
+
+	home := t.TempDir()
+	protoHome := filepath.Join(home, ".proto")
+	project := filepath.Join(home, "project", "app")
+	os.MkdirAll(protoHome, 0755)
+	os.MkdirAll(project, 0755)
+	t.Setenv("HOME", home)
+	t.Setenv("PROTO_HOME", protoHome)
+	t.Chdir(project)
+
+	tools := map[string]ToolStatus{
+		"bun":  {IsInstalled: true, ConfigSource: filepath.Join(project, ".prototools"), ProductDir: "/tools/bun/1.1.0"},
+		"go":   {IsInstalled: true, ConfigSource: filepath.Join(home, "project", ".prototools")},
+		"node": {IsInstalled: true},
+		"uv":   {IsInstalled: true},
+	}
+	outdated := map[string]OutdatedStatus{
+		"node": {ConfigSource: filepath.Join(protoHome, ".prototools")},
+	}
+
+	config := ProtoConfig{Template: "{{.Tool}}={{.SourceRelative}},{{.IsLocal}},{{.IsInherited}},{{.IsGlobal}},{{.ProductDir}};"}
+	output := formatOutput(tools, outdated, config, FetchInfo{})
+
+	expected := "bun=.prototools,true,false,false,/tools/bun/1.1.0;" +
+		"go=../.prototools,false,true,false,;" +
+		"node=../../.proto/.prototools,false,false,true,;" +
+		"uv=,false,false,false,;"
+	if output != expected {
+		t.Errorf("formatOutput() = %q, want %q", output, expected)
+	}
+}
+
+func TestFormatOutputUpdateType(t *testing.T) {
+	tools := map[string]ToolStatus{
+		"go":   {IsInstalled: true, ResolvedVersion: "1.22.0"},
+		"node": {IsInstalled: true, ResolvedVersion: "20.11.0"},
+		"bun":  {IsInstalled: true, ResolvedVersion: "1.1.0"},
+	}
+	outdated := map[string]OutdatedStatus{
+		"go":   {IsOutdated: true, NewestVersion: "1.22.5", LatestVersion: "1.23.0"},
+		"node": {IsOutdated: true, NewestVersion: "20.12.0", LatestVersion: "22.1.0"},
+	}
+
+	config := ProtoConfig{Template: "{{.Tool}}:{{.UpdateType}}/{{.LatestUpdateType}}/{{.MajorsBehind}} "}
+	output := formatOutput(tools, outdated, config, FetchInfo{})
+
+	expected := "bun:none/none/0 go:patch/minor/0 node:minor/major/2"
+	if output != expected {
+		t.Errorf("formatOutput() = %q, want %q", output, expected)
+	}
+}
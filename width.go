@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const defaultOverflow = " +{count}"
+
+// maxWidth returns the widest the segment may be in terminal columns, the
+// smaller of max_width and max_width_ratio of $COLUMNS. 0 is unlimited.
+func (d DisplayConfig) maxWidth() int {
+	width := d.MaxWidth
+	if d.MaxWidthRatio > 0 {
+		if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+			if fraction := int(float64(columns) * d.MaxWidthRatio); width == 0 || fraction < width {
+				width = fraction
+			}
+		}
+	}
+	return width
+}
+
+// overflowMarker renders the text appended when count tools were dropped.
+func (d DisplayConfig) overflowMarker(count int) string {
+	overflow := d.Overflow
+	if overflow == "" {
+		overflow = defaultOverflow
+	}
+	return strings.ReplaceAll(overflow, "{count}", strconv.Itoa(count))
+}
+
+// fitWidth drops tools from the end of toolData, the lowest priority given
+// the sort order, until render's output plus the overflow marker fits in
+// the configured width. A single tool that is too wide is dropped as well,
+// leaving only the marker.
+func fitWidth(toolData []TemplateData, display DisplayConfig, render func([]TemplateData) string) string {
+	output := render(toolData)
+	limit := display.maxWidth()
+	if limit <= 0 || visibleWidth(output, display.IconWidth) <= limit {
+		return output
+	}
+
+	for keep := len(toolData) - 1; keep >= 0; keep-- {
+		output = render(toolData[:keep]) + display.overflowMarker(len(toolData)-keep)
+		if visibleWidth(output, display.IconWidth) <= limit {
+			break
+		}
+	}
+	return output
+}
+
+// visibleWidth measures s in terminal columns. ANSI escape sequences take
+// no space, East Asian wide characters and emoji take two columns and
+// Nerd Font icons, which live in the Private Use Areas, take iconWidth
+// (1 if unset).
+func visibleWidth(s string, iconWidth int) int {
+	if iconWidth <= 0 {
+		iconWidth = 1
+	}
+
+	width := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			i += escapeLength(s[i:])
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case isPrivateUse(r):
+			width += iconWidth
+		default:
+			width += runeWidth(r)
+		}
+	}
+	return width
+}
+
+// escapeLength returns the length of the escape sequence s starts with:
+// CSI sequences such as colors, OSC sequences such as hyperlinks, or a
+// two-byte escape.
+func escapeLength(s string) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+	switch s[1] {
+	case '[':
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+	case ']':
+		for i := 2; i < len(s); i++ {
+			if s[i] == '\a' {
+				return i + 1
+			}
+			if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+	default:
+		return 2
+	}
+	return len(s)
+}
+
+func isPrivateUse(r rune) bool {
+	return (r >= 0xe000 && r <= 0xf8ff) || (r >= 0xf0000 && r <= 0xffffd) || (r >= 0x100000 && r <= 0x10fffd)
+}
+
+// wideRanges are the East Asian Wide and Fullwidth blocks and the emoji
+// blocks terminals draw in two columns.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115f},   // Hangul Jamo
+	{0x2e80, 0x303e},   // CJK Radicals to CJK Symbols and Punctuation
+	{0x3041, 0x33ff},   // Hiragana to CJK Compatibility
+	{0x3400, 0x4dbf},   // CJK Unified Ideographs Extension A
+	{0x4e00, 0x9fff},   // CJK Unified Ideographs
+	{0xa000, 0xa4cf},   // Yi
+	{0xac00, 0xd7a3},   // Hangul Syllables
+	{0xf900, 0xfaff},   // CJK Compatibility Ideographs
+	{0xfe30, 0xfe4f},   // CJK Compatibility Forms
+	{0xff00, 0xff60},   // Fullwidth Forms
+	{0xffe0, 0xffe6},   // Fullwidth Signs
+	{0x1f300, 0x1f64f}, // Miscellaneous Symbols and Pictographs, Emoticons
+	{0x1f680, 0x1f6ff}, // Transport and Map Symbols
+	{0x1f900, 0x1f9ff}, // Supplemental Symbols and Pictographs
+	{0x20000, 0x2fffd}, // CJK Unified Ideographs Extension B and later
+	{0x30000, 0x3fffd},
+}
+
+func runeWidth(r rune) int {
+	if r < 0x20 || r == 0x7f || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	for _, wide := range wideRanges {
+		if r >= wide[0] && r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
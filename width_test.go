@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVisibleWidth(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		iconWidth int
+		want      int
+	}{
+		{"plain", "node 22.1.0", 0, 11},
+		{"colors", "\x1b[38;5;60mnode\x1b[0m \x1b[32m22\x1b[0m", 0, 7},
+		{"hyperlink", "\x1b]8;;https://example.com\x1b\\link\x1b]8;;\a", 0, 4},
+		{"nerd font icon", "\ued0d 22", 0, 4},
+		{"wide nerd font icon", "\ued0d 22", 2, 5},
+		{"supplementary icon", "\U000f0b02", 0, 1},
+		{"east asian", "\u30ce\u30fc\u30c9", 0, 6},
+		{"emoji", "\U0001f680", 0, 2},
+		{"combining", "e\u0301", 0, 1},
+		{"zero width joiner", "a\u200db", 0, 2},
+		{"truncated escape", "ab\x1b[3", 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := visibleWidth(tt.input, tt.iconWidth); got != tt.want {
+				t.Errorf("visibleWidth(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisplayMaxWidth(t *testing.T) {
+	t.Setenv("COLUMNS", "100")
+	tests := []struct {
+		name    string
+		display DisplayConfig
+		want    int
+	}{
+		{"unlimited", DisplayConfig{}, 0},
+		{"fixed", DisplayConfig{MaxWidth: 40}, 40},
+		{"ratio", DisplayConfig{MaxWidthRatio: 0.25}, 25},
+		{"smaller of both", DisplayConfig{MaxWidth: 20, MaxWidthRatio: 0.25}, 20},
+		{"ratio smaller", DisplayConfig{MaxWidth: 40, MaxWidthRatio: 0.25}, 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.display.maxWidth(); got != tt.want {
+				t.Errorf("maxWidth() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Setenv("COLUMNS", "")
+	if got := (DisplayConfig{MaxWidth: 40, MaxWidthRatio: 0.25}).maxWidth(); got != 40 {
+		t.Errorf("maxWidth() without $COLUMNS = %d, want max_width", got)
+	}
+}
+
+func TestFormatOutputMaxWidth(t *testing.T) {
+	tools := map[string]ToolStatus{
+		"bun":  {IsInstalled: true, ResolvedVersion: "1.1.0"},
+		"go":   {IsInstalled: true, ResolvedVersion: "1.22.0"},
+		"node": {IsInstalled: true, ResolvedVersion: "22.1.0"},
+	}
+
+	tests := []struct {
+		name     string
+		display  DisplayConfig
+		layout   string
+		expected string
+	}{
+		{"fits", DisplayConfig{MaxWidth: 40}, "", "\x1b[32mbun 1.1.0\x1b[0m  \x1b[32mgo 1.22.0\x1b[0m  \x1b[32mnode 22.1.0\x1b[0m"},
+		{"drops last", DisplayConfig{MaxWidth: 25}, "", "\x1b[32mbun 1.1.0\x1b[0m  \x1b[32mgo 1.22.0\x1b[0m +1"},
+		{"custom overflow", DisplayConfig{MaxWidth: 15, Overflow: " …{count}"}, "", "\x1b[32mbun 1.1.0\x1b[0m …2"},
+		{"only marker", DisplayConfig{MaxWidth: 5}, "", " +3"},
+		{"layout", DisplayConfig{MaxWidth: 10}, "{{range .Tools}}{{.Tool}}{{if not .IsLast}}|{{end}}{{end}}", "bun|go +1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ProtoConfig{
+				Template:       "{{fgColor \"green\"}}{{.Tool}} {{.ResolvedVersion}}{{reset}}  ",
+				LayoutTemplate: tt.layout,
+				Display:        tt.display,
+			}
+			output := formatOutput(tools, nil, config, FetchInfo{OutdatedUnknown: true})
+			if output != tt.expected {
+				t.Errorf("formatOutput() = %q, want %q", output, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatOutputMaxWidthRendersKeptTools(t *testing.T) {
+	tools := map[string]ToolStatus{"aa": {}, "bb": {}, "cc": {}}
+
+	tests := []struct {
+		name     string
+		config   ProtoConfig
+		expected string
+	}{
+		{"template", ProtoConfig{
+			Template: "{{.Tool}}{{if not .IsLast}} | {{end}}",
+			Display:  DisplayConfig{MaxWidth: 10},
+		}, "aa | bb +1"},
+		{"layout", ProtoConfig{
+			Template:       "{{.Tool}}{{if not .IsLast}}, {{end}}",
+			LayoutTemplate: "[{{range .Tools}}{{.Output}}{{end}}]",
+			Display:        DisplayConfig{MaxWidth: 11},
+		}, "[aa, bb] +1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if output := formatOutput(tools, nil, tt.config, FetchInfo{}); output != tt.expected {
+				t.Errorf("formatOutput() = %q, want %q", output, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadRenderCacheChecksColumns(t *testing.T) {
+	oldConfigPath := configPath
+	oldGetCacheFile := getCacheFile
+	defer func() {
+		configPath = oldConfigPath
+		getCacheFile = oldGetCacheFile
+	}()
+
+	t.Setenv("HOME", t.TempDir())
+	configDir := t.TempDir()
+	configPath = filepath.Join(configDir, "config.jsonc")
+	os.WriteFile(configPath, []byte("{}"), 0644)
+	getCacheFile = func() string { return filepath.Join(configDir, "config.cache.json") }
+
+	t.Setenv("COLUMNS", "120")
+	inputs, ok := fingerprintInputs(ProtoConfig{Display: DisplayConfig{MaxWidthRatio: 0.5}})
+	if !ok {
+		t.Fatal("fingerprintInputs() failed")
+	}
+	writeRenderCache(inputs, "wide", time.Now().Unix()+60)
+	if _, ok := readRenderCache(); !ok {
+		t.Fatal("readRenderCache() missed with the same $COLUMNS")
+	}
+
+	t.Setenv("COLUMNS", "80")
+	if _, ok := readRenderCache(); ok {
+		t.Error("readRenderCache() should miss after $COLUMNS changed")
+	}
+}